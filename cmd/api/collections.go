@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"net/http"
+)
+
+func (app *application) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description"`
+		Public      bool    `json:"public"`
+		Movies      []int64 `json:"movies"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	collection := &data.Collection{
+		UserID:      app.contextGetUser(r).ID,
+		Name:        input.Name,
+		Description: input.Description,
+		Public:      input.Public,
+		Movies:      input.Movies,
+	}
+
+	// a collection can be created empty, and have movies added to it later.
+	if collection.Movies == nil {
+		collection.Movies = []int64{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Insert(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownMovie):
+			v.AddError("movies", "must only contain existing movies")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/collections/%d", collection.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelop{"collection": collection}, headers)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the readCollection() helper fetches the collection named in the URL for the current
+// user. Private collections belonging to other users are reported as not found, so
+// that their existence isn't revealed. If ownerOnly is true, the user must also own
+// the collection. If the collection can't be returned it sends the appropriate error
+// response and returns nil.
+func (app *application) readCollection(w http.ResponseWriter, r *http.Request, ownerOnly bool) *data.Collection {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	collection, err := app.models.Collections.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return nil
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	if !collection.VisibleTo(userID) {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	if ownerOnly && collection.UserID != userID {
+		app.notPermittedResponse(w, r)
+		return nil
+	}
+
+	return collection
+}
+
+func (app *application) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, false)
+	if collection == nil {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelop{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the updateCollectionHandler() updates a collection owned by the user. If "movies" is
+// given it replaces the whole list, so movies are added, removed and reordered by
+// sending the list as it should be.
+func (app *application) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, true)
+	if collection == nil {
+		return
+	}
+
+	var input struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+		Public      *bool   `json:"public"`
+		Movies      []int64 `json:"movies"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		collection.Name = *input.Name
+	}
+
+	if input.Description != nil {
+		collection.Description = *input.Description
+	}
+
+	if input.Public != nil {
+		collection.Public = *input.Public
+	}
+
+	if input.Movies != nil {
+		collection.Movies = input.Movies
+	}
+
+	v := validator.New()
+
+	if data.ValidateCollection(v, collection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Collections.Update(collection)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrUnknownMovie):
+			v.AddError("movies", "must only contain existing movies")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"collection": collection}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collection := app.readCollection(w, r, true)
+	if collection == nil {
+		return
+	}
+
+	err := app.models.Collections.Delete(collection.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "collection successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the listCollectionsHandler() lists the public collections along with the user's own
+// private ones, or only the user's own collections with mine=true.
+func (app *application) listCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Mine *bool
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Mine = app.readBool(qs, "mine", v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "created_at", "-id", "-name", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	userID := app.contextGetUser(r).ID
+
+	var ownerID int64
+	if input.Mine != nil && *input.Mine {
+		ownerID = userID
+	}
+
+	collections, metadata, err := app.models.Collections.GetAll(userID, ownerID, input.Filters)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"collections": collections, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
@@ -16,6 +16,10 @@ type contextKey string
 
 const userContextKey = contextKey("user")
 
+// the tokenContextKey is used for the plaintext authentication token that the request
+// was authenticated with, if any.
+const tokenContextKey = contextKey("token")
+
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context. Note that we use our userContextKey constant as the
 // key.
@@ -37,3 +41,23 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+// The contextSetToken() method returns a new copy of the request with the plaintext
+// authentication token added to the context.
+
+func (app *application) contextSetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// The contextGetToken() retrieves the plaintext authentication token from the request
+// context. Like contextGetUser(), it should only be used where the request is known
+// to be authenticated, and it panics otherwise.
+
+func (app *application) contextGetToken(r *http.Request) string {
+	token, ok := r.Context().Value(tokenContextKey).(string)
+	if !ok {
+		panic("missing token value in request context")
+	}
+	return token
+}
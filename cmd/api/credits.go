@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"net/http"
+)
+
+func (app *application) listCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	credits, err := app.models.Credits.GetAllForMovie(movieID)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"credits": credits}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) createCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		PersonID     int64  `json:"person_id"`
+		Role         string `json:"role"`
+		Character    string `json:"character"`
+		BillingOrder int32  `json:"billing_order"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	credit := &data.Credit{
+		MovieID:      movieID,
+		PersonID:     input.PersonID,
+		Role:         input.Role,
+		Character:    input.Character,
+		BillingOrder: input.BillingOrder,
+	}
+
+	v := validator.New()
+
+	if data.ValidateCredit(v, credit); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Credits.Insert(credit)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrPersonNotFound):
+			v.AddError("person_id", "must refer to an existing person")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelop{"credit": credit}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) deleteCreditHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	creditID, err := app.readNamedIDParam(r, "credit_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Credits.Delete(movieID, creditID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "credit successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
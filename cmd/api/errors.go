@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 )
 
 // the logError() method is a generic helper for logging an error message.
@@ -72,6 +73,11 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
 
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the record has been changed since you last fetched it, please fetch it again"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, message)
+}
+
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
 	app.errorResponse(w, r, http.StatusTooManyRequests, message)
@@ -103,3 +109,8 @@ func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Requ
 	message := "your user account doesn't have the necessary permissions to acccess this resource"
 	app.errorResponse(w, r, http.StatusForbidden, message)
 }
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, supported ...string) {
+	message := fmt.Sprintf("the request body must have one of the content types: %s", strings.Join(supported, ", "))
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
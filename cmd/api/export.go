@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the exportMoviesHandler() handles "GET /v1/movies/export". It streams every movie
+// matching the title, genres, year and runtime filters as CSV or newline-delimited
+// JSON, writing each row to the client as it is read from the database, rather than
+// building the whole response in memory like writeJSON() does. The CSV columns are a superset of those
+// accepted by POST /v1/movies/import.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieFilters
+		Format string
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.GenresMode = app.readString(qs, "genres_mode", "all")
+	input.YearMin = int32(app.readInt(qs, "year_min", 0, v))
+	input.YearMax = int32(app.readInt(qs, "year_max", 0, v))
+	input.RuntimeMin = app.readRuntime(qs, "runtime_min", v)
+	input.RuntimeMax = app.readRuntime(qs, "runtime_max", v)
+	input.Format = app.readString(qs, "format", "csv")
+
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	data.ValidateMovieFilters(v, &input.MovieFilters, genres)
+	v.Check(validator.In(input.Format, "csv", "ndjson"), "format", "must be csv or ndjson")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// a full export can take longer than the server's write timeout, so extend the
+	// deadline for this response.
+	err = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(10 * time.Minute))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	var (
+		contentType string
+		writeHeader func() error
+		writeMovie  func(*data.Movie) error
+		flush       func() error
+	)
+
+	switch input.Format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		rows := 0
+
+		contentType = "text/csv"
+		writeHeader = func() error {
+			return cw.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+		}
+		writeMovie = func(movie *data.Movie) error {
+			err := cw.Write([]string{
+				strconv.FormatInt(movie.ID, 10),
+				movie.Title,
+				strconv.FormatInt(int64(movie.Year), 10),
+				strconv.FormatInt(int64(movie.Runtime), 10) + " mins",
+				strings.Join(movie.Genres, ","),
+				strconv.FormatInt(int64(movie.Version), 10),
+			})
+			if err != nil {
+				return err
+			}
+
+			// the csv.Writer is buffered, so send the rows to the client every so often.
+			rows++
+			if rows%100 == 0 {
+				return flush()
+			}
+			return nil
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+
+	case "ndjson":
+		enc := json.NewEncoder(w)
+
+		contentType = "application/x-ndjson"
+		writeHeader = func() error { return nil }
+		writeMovie = func(movie *data.Movie) error { return enc.Encode(movie) }
+		flush = func() error { return nil }
+	}
+
+	// the response headers are only sent when the first movie is read, so that we can
+	// still send a normal error response if the query fails up front.
+	started := false
+	begin := func() error {
+		if started {
+			return nil
+		}
+		started = true
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.`+input.Format+`"`)
+		w.WriteHeader(http.StatusOK)
+
+		return writeHeader()
+	}
+
+	err = app.models.Movies.Export(input.MovieFilters, func(movie *data.Movie) error {
+		if err := begin(); err != nil {
+			return err
+		}
+		return writeMovie(movie)
+	})
+	if err == nil {
+		// an export without any movies still gets a response (and CSV header line).
+		err = begin()
+	}
+	if err == nil {
+		err = flush()
+	}
+
+	if err != nil {
+		// once the response has started we can't send an error response any more,
+		// so all we can do is log the error. The client will see a truncated body.
+		if started {
+			app.logError(r, err)
+			return
+		}
+		app.serverErrorRespone(w, r, err)
+	}
+}
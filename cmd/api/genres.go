@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"net/http"
+)
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    string   `json:"name"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	genre := &data.Genre{
+		Name:    input.Name,
+		Aliases: input.Aliases,
+	}
+
+	// the aliases can be left out when creating a genre.
+	if genre.Aliases == nil {
+		genre.Aliases = []string{}
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Insert(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("name", "a genre with this name or alias already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%d", genre.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelop{"genre": genre}, headers)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) showGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the updateGenreHandler() renames a genre or replaces its aliases. Renaming a genre
+// also renames it on every movie which has it.
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name    *string  `json:"name"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	oldName := genre.Name
+
+	if input.Name != nil {
+		genre.Name = *input.Name
+	}
+
+	// the aliases are replaced as a whole list.
+	if input.Aliases != nil {
+		genre.Aliases = input.Aliases
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Update(genre, oldName, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("name", "a genre with this name or alias already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the mergeGenreHandler() handles "POST /v1/genres/:id/merge", which folds the genre
+// into the genre given by "into" in the body. The merged genre's name and aliases
+// become aliases of the other genre, and its movies are moved over to the other genre.
+func (app *application) mergeGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	source, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Into int64 `json:"into"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Into != 0, "into", "must be provided")
+	v.Check(input.Into != source.ID, "into", "must be a different genre")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	target, err := app.models.Genres.Get(input.Into)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("into", "must be an existing genre")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Genres.Merge(source, target, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"genre": target}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) deleteGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Genres.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrGenreInUse):
+			app.errorResponse(w, r, http.StatusConflict, "the genre is still used by some movies, merge it into another genre instead")
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "genre successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"greenlight.mayuraandrew.tech/internal/data"
 	"greenlight.mayuraandrew.tech/internal/validator"
 	"io"
 	"net/http"
@@ -29,11 +32,18 @@ type envelop map[string]interface{}
 // retrieve a slice containing these parameter names and values.
 
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// the readNamedIDParam() helper works in the same way as readIDParam(), but for routes
+// which contain more than one ID parameter, such as "/v1/movies/:id/reviews/:review_id".
+
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 	return id, nil
 }
@@ -206,17 +216,179 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// the readBool() helper reads a "true" or "false" value from the query string. Unlike
+// the other helpers it returns a pointer, which is nil if no matching key could be
+// found, so that callers can tell the difference between false and not provided. If
+// the value couldn't be converted to a boolean, then we record an error message in the
+// provided Validator instance.
+
+func (app *application) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return nil
+	}
+
+	return &b
+}
+
+// the readRuntime() helper reads a runtime in the "<runtime> mins" format used in JSON
+// from the query string. If no matching key could be found it returns zero. If the
+// value couldn't be parsed, then we record an error message in the provided Validator
+// instance.
+
+func (app *application) readRuntime(qs url.Values, key string, v *validator.Validator) data.Runtime {
+	s := qs.Get(key)
+
+	if s == "" {
+		return 0
+	}
+
+	runtime, err := data.ParseRuntime(s)
+	if err != nil {
+		v.AddError(key, `must be in the format "<runtime> mins"`)
+		return 0
+	}
+
+	return runtime
+}
+
+// the readProjection() helper reads the fields= and include= query string parameters
+// into a data.Projection, with the given safelists.
+func (app *application) readProjection(qs url.Values, fieldsSafelist, includeSafelist []string) data.Projection {
+	return data.Projection{
+		Fields:          app.readCSV(qs, "fields", []string{}),
+		FieldsSafelist:  fieldsSafelist,
+		Include:         app.readCSV(qs, "include", []string{}),
+		IncludeSafelist: includeSafelist,
+	}
+}
+
+// the project() helper returns the JSON object for v with only the members selected by
+// the projection: the requested fields, plus any included related data. If no fields
+// were requested, v is returned unchanged.
+func project(v any, p data.Projection) (any, error) {
+	if len(p.Fields) == 0 {
+		return v, nil
+	}
+
+	js, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var members map[string]json.RawMessage
+
+	err = json.Unmarshal(js, &members)
+	if err != nil {
+		return nil, err
+	}
+
+	projected := make(map[string]json.RawMessage, len(p.Fields)+len(p.Include))
+	for _, names := range [][]string{p.Fields, p.Include} {
+		for _, name := range names {
+			if value, ok := members[name]; ok {
+				projected[name] = value
+			}
+		}
+	}
+
+	return projected, nil
+}
+
+// the movieETag() helper returns the strong entity tag for a movie, made from its ID and
+// version number. The version is incremented every time the movie itself is changed,
+// so this is the tag that If-Match is checked against before any changes are written.
+// Reviews don't change the version, so posting one doesn't make an If-Match fail.
+func movieETag(movie *data.Movie) string {
+	return fmt.Sprintf(`"%d-%d"`, movie.ID, movie.Version)
+}
+
+// the projectionETag() helper returns a weak entity tag for a projection of a movie,
+// as returned with fields= or include=. It adds a hash of the projected JSON to the
+// movie's ID and version, as the ratings and related data in it can change without the
+// version changing. Being weak, it is only used for If-None-Match, never If-Match.
+func projectionETag(movie *data.Movie, projected any) (string, error) {
+	js, err := json.Marshal(projected)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(js)
+	return fmt.Sprintf(`W/"%d-%d-%s"`, movie.ID, movie.Version, hex.EncodeToString(sum[:8])), nil
+}
+
+// the etagMatches() helper reports whether an If-Match or If-None-Match header value
+// matches the given entity tag. The header can contain a list of tags or "*". If-Match
+// uses the strong comparison, where weak tags (prefixed with W/) never match, while
+// If-None-Match uses the weak comparison, which ignores the W/ prefix.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+
+		if tag == "*" {
+			return true
+		}
+
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// the checkIfMatch() helper enforces an If-Match request header against the current
+// state of a movie, before any changes are written. If the header is present and
+// doesn't match, it sends a 412 Precondition Failed response and returns false.
+// Without an If-Match header, the older X-Expected-Version header is checked against
+// the movie's version number instead, with a 409 Conflict response if it differs.
+func (app *application) checkIfMatch(w http.ResponseWriter, r *http.Request, movie *data.Movie) bool {
+	if header := r.Header.Get("If-Match"); header != "" {
+		if etagMatches(header, movieETag(movie), false) {
+			return true
+		}
+
+		app.preconditionFailedResponse(w, r)
+		return false
+	}
+
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" {
+		if strconv.FormatInt(int64(movie.Version), 10) != expected {
+			app.editConflictResponse(w, r)
+			return false
+		}
+	}
+
+	return true
+}
+
 // the background() helper accepts an arbitrary function as a parameter.
 
 func (app *application) background(fn func()) {
 	// launch a background goroutine.
 	go func() {
 		// recover any panic.
-		if err := recover(); err != nil {
-			app.logger.PrintError(fmt.Errorf("%s", err), nil)
-		}
-	}()
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.PrintError(fmt.Errorf("%s", err), nil)
+			}
+		}()
 
-	// execute the arbitrary function that we passed as the parameter.
-	fn()
+		// execute the arbitrary function that we passed as the parameter. It runs inside
+		// the goroutine, so that the response isn't held up by it.
+		fn()
+	}()
 }
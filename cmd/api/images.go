@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/imaging"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// the limits on uploaded artwork. The pixel limits stop a small, highly compressed file
+// from using a huge amount of memory when it is decoded.
+const (
+	maxImageBytes  = 10 * 1_048_576
+	maxImageWidth  = 6000
+	maxImageHeight = 6000
+)
+
+// the image formats which can be uploaded, and the file extensions they are stored with.
+var imageExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+// a thumbnailSize is one of the sizes that thumbnails are generated at, by width.
+type thumbnailSize struct {
+	name  string
+	width int
+}
+
+// the thumbnail sizes for each kind of image. An uploaded image must be at least as
+// wide as its smallest thumbnail.
+var thumbnailSizes = map[string][]thumbnailSize{
+	"poster":   {{"small", 185}, {"medium", 342}, {"large", 780}},
+	"backdrop": {{"small", 300}, {"medium", 780}, {"large", 1280}},
+}
+
+// the putMovieImageHandler() returns the handler for "PUT /v1/movies/:id/poster" and
+// "PUT /v1/movies/:id/backdrop". The image can be sent as the raw request body, or as
+// the "image" field of a multipart/form-data body. The original image is stored along
+// with JPEG thumbnails, and the image that it replaces is deleted.
+func (app *application) putMovieImageHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorRespone(w, r, err)
+			}
+			return
+		}
+
+		if !app.checkIfMatch(w, r, movie) {
+			return
+		}
+
+		body, ok := app.readImageBody(w, r)
+		if !ok {
+			return
+		}
+
+		// check the actual content of the file, rather than trusting the Content-Type
+		// that the client sent.
+		contentType := http.DetectContentType(body)
+		extension, ok := imageExtensions[contentType]
+		if !ok {
+			app.unsupportedMediaTypeResponse(w, r, "image/jpeg", "image/png", "image/gif")
+			return
+		}
+
+		v := validator.New()
+
+		// read the dimensions from the image header before decoding the whole image.
+		config, _, err := image.DecodeConfig(bytes.NewReader(body))
+		if err != nil {
+			v.AddError("image", "must be a valid image file")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		sizes := thumbnailSizes[kind]
+		minWidth := sizes[0].width
+
+		v.Check(config.Width >= minWidth, "image", fmt.Sprintf("must be at least %d pixels wide", minWidth))
+		v.Check(config.Width <= maxImageWidth, "image", fmt.Sprintf("must not be more than %d pixels wide", maxImageWidth))
+		v.Check(config.Height <= maxImageHeight, "image", fmt.Sprintf("must not be more than %d pixels high", maxImageHeight))
+
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		src, _, err := image.Decode(bytes.NewReader(body))
+		if err != nil {
+			v.AddError("image", "must be a valid image file")
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		// every upload is stored under a new random prefix, so that the URLs of the
+		// new image never collide with (possibly cached) URLs of the old one.
+		token := make([]byte, 8)
+		_, err = rand.Read(token)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+		prefix := fmt.Sprintf("movies/%d/%s/%s", movie.ID, kind, hex.EncodeToString(token))
+
+		img := &data.Image{
+			Width:      config.Width,
+			Height:     config.Height,
+			Thumbnails: make(map[string]string),
+		}
+
+		put := func(key string, file []byte, contentType string) error {
+			err := app.storage.Put(r.Context(), key, file, contentType)
+			if err != nil {
+				return err
+			}
+			img.Keys = append(img.Keys, key)
+			return nil
+		}
+
+		originalKey := prefix + "/original." + extension
+		err = put(originalKey, body, contentType)
+		if err == nil {
+			img.URL = app.storage.URL(originalKey)
+
+			for _, size := range sizes {
+				var buf bytes.Buffer
+
+				err = jpeg.Encode(&buf, imaging.Thumbnail(src, size.width), &jpeg.Options{Quality: 85})
+				if err != nil {
+					break
+				}
+
+				key := prefix + "/" + size.name + ".jpg"
+				err = put(key, buf.Bytes(), "image/jpeg")
+				if err != nil {
+					break
+				}
+				img.Thumbnails[size.name] = app.storage.URL(key)
+			}
+		}
+		if err != nil {
+			app.deleteImageFiles(img)
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+
+		old, err := app.models.Movies.SetImage(movie, kind, img, app.contextGetUser(r).ID)
+		if err != nil {
+			app.deleteImageFiles(img)
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorRespone(w, r, err)
+			}
+			return
+		}
+
+		app.deleteImageFiles(old)
+
+		headers := make(http.Header)
+		headers.Set("ETag", movieETag(movie))
+
+		err = app.writeJSON(w, http.StatusOK, envelop{"movie": movie}, headers)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+		}
+	}
+}
+
+// the deleteMovieImageHandler() returns the handler for "DELETE /v1/movies/:id/poster"
+// and "DELETE /v1/movies/:id/backdrop", which remove the image and its files.
+func (app *application) deleteMovieImageHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := app.readIDParam(r)
+		if err != nil {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorRespone(w, r, err)
+			}
+			return
+		}
+
+		if !app.checkIfMatch(w, r, movie) {
+			return
+		}
+
+		if (kind == "poster" && movie.Poster == nil) || (kind == "backdrop" && movie.Backdrop == nil) {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		old, err := app.models.Movies.SetImage(movie, kind, nil, app.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorRespone(w, r, err)
+			}
+			return
+		}
+
+		app.deleteImageFiles(old)
+
+		err = app.writeJSON(w, http.StatusOK, envelop{"message": kind + " successfully deleted"}, nil)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+		}
+	}
+}
+
+// the readImageBody() helper reads an uploaded image from either a raw image body or
+// the "image" field of a multipart/form-data body. If the body can't be read it sends
+// the appropriate error response and returns false.
+func (app *application) readImageBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImageBytes)
+
+	var (
+		body []byte
+		err  error
+	)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "multipart/form-data":
+		body, err = readMultipartImage(r)
+
+	case strings.HasPrefix(mediaType, "image/"):
+		body, err = io.ReadAll(r.Body)
+
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "image/jpeg", "image/png", "image/gif", "multipart/form-data")
+		return nil, false
+	}
+
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.errorResponse(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("image must not be larger than %d bytes", maxImageBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	if len(body) == 0 {
+		app.badRequestResponse(w, r, errors.New("image must not be empty"))
+		return nil, false
+	}
+
+	return body, true
+}
+
+// readMultipartImage() returns the contents of the "image" field of a multipart body.
+func readMultipartImage(r *http.Request) ([]byte, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New(`body must contain an "image" field`)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if part.FormName() == "image" {
+			defer part.Close()
+			return io.ReadAll(part)
+		}
+		part.Close()
+	}
+}
+
+// the serveImages() helper returns a file server for the directory of uploaded images.
+// Requests for directories get a 404 Not Found response, rather than a listing of the
+// files in them.
+func (app *application) serveImages(dir http.FileSystem) http.Handler {
+	files := http.FileServer(dir)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			app.notFoundResponse(w, r)
+			return
+		}
+
+		// the files are never changed once they are written, so they can be cached.
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		files.ServeHTTP(w, r)
+	})
+}
+
+// the deleteImageFiles() helper removes the stored files of an image which is no
+// longer used. Failures are only logged, as the image has already been detached from
+// the movie and the files are just taking up space.
+func (app *application) deleteImageFiles(img *data.Image) {
+	if img == nil {
+		return
+	}
+
+	for _, key := range img.Keys {
+		err := app.storage.Delete(context.Background(), key)
+		if err != nil {
+			app.logger.PrintError(err, map[string]string{"key": key})
+		}
+	}
+}
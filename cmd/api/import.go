@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// the limits on the size of a bulk import. These are much larger than the 1MB limit
+// in readJSON(), but still stop a single request from tying up the server.
+const (
+	maxImportBytes = 10 * 1_048_576
+	maxImportRows  = 10_000
+)
+
+// an importRow holds the outcome for a single row of a bulk import. Rows are numbered
+// from 1, not counting the CSV header line.
+type importRow struct {
+	Row    int               `json:"row"`
+	Title  string            `json:"title,omitempty"`
+	Status string            `json:"status"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// a movieRowFunc is called by the import readers for each row in the body. If the row
+// couldn't be parsed then movie is nil, and v contains the reason.
+type movieRowFunc func(row int, movie *data.Movie, v *validator.Validator) error
+
+// the importMoviesHandler() handles "POST /v1/movies/import". It accepts a CSV file
+// (with a title,year,runtime,genres header) or newline-delimited JSON objects in the
+// same format as the POST /v1/movies body. Every row is validated, the valid rows are
+// inserted together in a single transaction, and a report of the outcome for each row
+// is returned. With dry_run=true nothing is inserted.
+func (app *application) importMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	dryRun := app.readBool(r.URL.Query(), "dry_run", v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var readRows func(io.Reader, movieRowFunc) error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		readRows = readMoviesCSV
+	case "application/x-ndjson":
+		readRows = readMoviesNDJSON
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "text/csv", "application/x-ndjson")
+		return
+	}
+
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	rows := []importRow{}
+	movies := []*data.Movie{}
+
+	err = readRows(r.Body, func(row int, movie *data.Movie, v *validator.Validator) error {
+		if row > maxImportRows {
+			return fmt.Errorf("body must not contain more than %d rows", maxImportRows)
+		}
+
+		if movie != nil {
+			data.ValidateMovie(v, movie, genres)
+		}
+
+		result := importRow{Row: row, Status: "accepted"}
+		if movie != nil {
+			result.Title = movie.Title
+		}
+
+		if !v.Valid() {
+			result.Status = "rejected"
+			result.Errors = v.Errors
+		} else {
+			movies = append(movies, movie)
+		}
+
+		rows = append(rows, result)
+		return nil
+	})
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("body must not be larger than %d bytes", maxImportBytes))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(rows) == 0 {
+		app.badRequestResponse(w, r, errors.New("body must contain at least one row"))
+		return
+	}
+
+	if (dryRun == nil || !*dryRun) && len(movies) > 0 {
+		err = app.models.Movies.InsertMany(movies, app.contextGetUser(r).ID)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+	}
+
+	report := envelop{
+		"dry_run":  dryRun != nil && *dryRun,
+		"accepted": len(movies),
+		"rejected": len(rows) - len(movies),
+		"rows":     rows,
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"import": report}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// readMoviesCSV() reads movies from a CSV body. The first line must be a header naming
+// the title, year, runtime and genres columns (in any order). Runtimes use the same
+// "<runtime> mins" format as the JSON API, and genres are separated by commas.
+func readMoviesCSV(body io.Reader, fn movieRowFunc) error {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		switch {
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		default:
+			return fmt.Errorf("body contains an invalid CSV header: %w", err)
+		}
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	for _, name := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[name]; !ok {
+			return fmt.Errorf("CSV header must include the %q column", name)
+		}
+	}
+
+	// every record must have the same number of fields as the header.
+	reader.FieldsPerRecord = len(header)
+
+	for row := 1; ; row++ {
+		v := validator.New()
+
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		// a malformed line only rejects that row; the reader carries on with the
+		// next line.
+		var parseError *csv.ParseError
+		if errors.As(err, &parseError) {
+			v.AddError("row", parseError.Err.Error())
+			if err := fn(row, nil, v); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		movie := &data.Movie{
+			Title: record[columns["title"]],
+		}
+
+		year, err := strconv.ParseInt(strings.TrimSpace(record[columns["year"]]), 10, 32)
+		if err != nil {
+			v.AddError("year", "must be an integer value")
+		}
+		movie.Year = int32(year)
+
+		movie.Runtime, err = data.ParseRuntime(strings.TrimSpace(record[columns["runtime"]]))
+		if err != nil {
+			v.AddError("runtime", err.Error())
+		}
+
+		if genres := record[columns["genres"]]; genres != "" {
+			movie.Genres = strings.Split(genres, ",")
+			for i := range movie.Genres {
+				movie.Genres[i] = strings.TrimSpace(movie.Genres[i])
+			}
+		}
+
+		if err := fn(row, movie, v); err != nil {
+			return err
+		}
+	}
+}
+
+// readMoviesNDJSON() reads movies from a body containing one JSON object per line,
+// with the same fields as the POST /v1/movies request body. Blank lines are skipped,
+// and rows are numbered by line.
+func readMoviesNDJSON(body io.Reader, fn movieRowFunc) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+
+	for row := 1; scanner.Scan(); row++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		v := validator.New()
+
+		var input struct {
+			Title   string       `json:"title"`
+			Year    int32        `json:"year"`
+			Runtime data.Runtime `json:"runtime"`
+			Genres  []string     `json:"genres"`
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+
+		err := dec.Decode(&input)
+		if err == nil && dec.More() {
+			err = errors.New("line must only contain a single JSON value")
+		}
+		if err != nil {
+			v.AddError("row", err.Error())
+			if err := fn(row, nil, v); err != nil {
+				return err
+			}
+			continue
+		}
+
+		movie := &data.Movie{
+			Title:   input.Title,
+			Year:    input.Year,
+			Runtime: input.Runtime,
+			Genres:  input.Genres,
+		}
+
+		if err := fn(row, movie, v); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		switch {
+		case errors.Is(err, bufio.ErrTooLong):
+			return errors.New("body contains a line longer than 1048576 bytes")
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
@@ -18,6 +20,8 @@ import (
 	"greenlight.mayuraandrew.tech/internal/data"
 	"greenlight.mayuraandrew.tech/internal/jsonlog"
 	"greenlight.mayuraandrew.tech/internal/mailer"
+	"greenlight.mayuraandrew.tech/internal/storage"
+	"greenlight.mayuraandrew.tech/internal/validator"
 	"greenlight.mayuraandrew.tech/internal/vcs"
 	// compiler complaining that the package isn't being used.
 )
@@ -58,14 +62,50 @@ type config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	// the secret key used to sign the pagination cursors we send to clients.
+	cursor struct {
+		secret string
+	}
+	// the PostgreSQL text search configuration used to stem words in title searches.
+	search struct {
+		language string
+	}
+	// how long deleted movies are kept in the trash before they are purged for good.
+	trash struct {
+		retention time.Duration
+	}
+	// whether PUT /v1/movies/:id creates the movie when there isn't one with that ID.
+	movies struct {
+		createOnPut bool
+	}
+	// where uploaded movie artwork is stored: in a local directory, or in a bucket of
+	// an S3-compatible object store. url is the public URL the files are served from.
+	storage struct {
+		backend string
+		dir     string
+		url     string
+		s3      struct {
+			endpoint  string
+			region    string
+			bucket    string
+			accessKey string
+			secretKey string
+		}
+	}
 }
 
 // an application struct to hold the dependencies for HTTP handlers, helpers, and middleware.
 type application struct {
-	config config
-	logger *jsonlog.Logger
-	models data.Models
-	mailer mailer.Mailer
+	config  config
+	logger  *jsonlog.Logger
+	models  data.Models
+	mailer  mailer.Mailer
+	storage storage.Storage
+
+	// activationThrottle and passwordResetThrottle limit how often activation and
+	// password reset emails are sent to an address.
+	activationThrottle    *throttle
+	passwordResetThrottle *throttle
 }
 
 // the main function code
@@ -137,6 +177,23 @@ func main() {
 		return nil
 	})
 
+	flag.StringVar(&cfg.cursor.secret, "cursor-secret", os.Getenv("GREENLIGHT_CURSOR_SECRET"), "Secret key for signing pagination cursors")
+
+	flag.StringVar(&cfg.search.language, "search-language", "simple", "Text search language for movie titles (simple|english|french|...)")
+
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept in the trash (0 to keep them forever)")
+
+	flag.BoolVar(&cfg.movies.createOnPut, "movies-create-on-put", false, "Create movies which don't exist on PUT /v1/movies/:id")
+
+	flag.StringVar(&cfg.storage.backend, "storage", "local", "Storage backend for uploaded images (local|s3)")
+	flag.StringVar(&cfg.storage.dir, "storage-dir", "./uploads", "Directory for uploaded images with the local storage backend")
+	flag.StringVar(&cfg.storage.url, "storage-url", "", "Public base URL of uploaded images (default: served by the API, or the S3 bucket URL)")
+	flag.StringVar(&cfg.storage.s3.endpoint, "s3-endpoint", "", "S3-compatible endpoint URL, e.g. https://s3.us-east-1.amazonaws.com")
+	flag.StringVar(&cfg.storage.s3.region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.storage.s3.bucket, "s3-bucket", "", "S3 bucket for uploaded images")
+	flag.StringVar(&cfg.storage.s3.accessKey, "s3-access-key", os.Getenv("GREENLIGHT_S3_ACCESS_KEY"), "S3 access key")
+	flag.StringVar(&cfg.storage.s3.secretKey, "s3-secret-key", os.Getenv("GREENLIGHT_S3_SECRET_KEY"), "S3 secret key")
+
 	displayVersion := flag.Bool("version", false, "Display version and exit")
 	flag.Parse()
 
@@ -150,6 +207,44 @@ func main() {
 	// prefixed with the current date and time.
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// if no cursor secret was provided, generate a random one. This means that any
+	// cursors handed out will stop working when the application is restarted.
+	if cfg.cursor.secret == "" {
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		cfg.cursor.secret = string(secret)
+		logger.PrintInfo("no cursor secret provided, using a random one", nil)
+	}
+
+	// check that the search language is one that PostgreSQL ships with.
+	if !validator.In(cfg.search.language, data.SearchLanguages...) {
+		logger.PrintFatal(fmt.Errorf("invalid search language %q", cfg.search.language), nil)
+	}
+
+	// set up the storage for uploaded images.
+	var store storage.Storage
+	switch cfg.storage.backend {
+	case "local":
+		if cfg.storage.url == "" {
+			cfg.storage.url = fmt.Sprintf("http://localhost:%d/v1/images", cfg.port)
+		}
+		store, err = storage.NewLocal(cfg.storage.dir, cfg.storage.url)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	case "s3":
+		if cfg.storage.s3.endpoint == "" || cfg.storage.s3.bucket == "" {
+			logger.PrintFatal(errors.New("the s3 storage backend requires -s3-endpoint and -s3-bucket"), nil)
+		}
+		store = storage.NewS3(cfg.storage.s3.endpoint, cfg.storage.s3.region, cfg.storage.s3.bucket,
+			cfg.storage.s3.accessKey, cfg.storage.s3.secretKey, cfg.storage.url)
+	default:
+		logger.PrintFatal(fmt.Errorf("invalid storage backend %q", cfg.storage.backend), nil)
+	}
+
 	// call the openDB() helper function to create the connection pool.
 
 	db, err := openDB(cfg)
@@ -183,12 +278,24 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	models := data.NewModels(db)
+	models.Movies.SearchLanguage = cfg.search.language
+
 	// declare an instance of the application struct, containing the config struct and the logger.
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		config:  cfg,
+		logger:  logger,
+		models:  models,
+		mailer:  mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender),
+		storage: store,
+
+		activationThrottle:    newThrottle(5 * time.Minute),
+		passwordResetThrottle: newThrottle(5 * time.Minute),
+	}
+
+	// start purging old movies from the trash in the background.
+	if cfg.trash.retention > 0 {
+		go app.purgeTrash()
 	}
 
 	err = app.serve()
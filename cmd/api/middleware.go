@@ -5,6 +5,7 @@ import (
 	"expvar"
 	"fmt"
 	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 	"greenlight.mayuraandrew.tech/internal/data"
@@ -191,10 +192,21 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			}
 			return
 		}
+
+		// record where the token was used from, so that the user can see it in their
+		// list of sessions. This is only bookkeeping, so if the write fails we log the
+		// error and carry on with the request.
+		err = app.models.Tokens.Touch(token, r.UserAgent(), realip.FromRequest(r))
+		if err != nil {
+			app.logError(r, err)
+		}
+
 		// Call the contextSetUser() helper to add the user information to the request
-		// context.
+		// context, along with the token so that handlers can tell the current session
+		// apart from the user's others.
 
 		r = app.contextSetUser(r, user)
+		r = app.contextSetToken(r, token)
 
 		// call the next handler in the chain
 		next.ServeHTTP(w, r)
@@ -288,6 +300,24 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 	return app.requireActivatedUser(fn)
 }
 
+// httprouter doesn't allow a fixed path segment to share its position with a named
+// parameter, so a route like "/v1/movies/autocomplete" can't be registered alongside
+// "/v1/movies/:id". The staticSegments() middleware is registered on the
+// parameterized route instead, and dispatches requests where the parameter holds one
+// of the given names to the matching handler.
+func (app *application) staticSegments(param string, handlers map[string]http.HandlerFunc, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := handlers[params.ByName(param)]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Vary", "Origin")
@@ -310,6 +340,10 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 					// out of the loop.
 					w.Header().Set("Access-Control-Allow-Origin", origin)
 
+					// let browser clients read the ETag header, so that they can send
+					// it back in an If-Match or If-None-Match header.
+					w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
 					// Check if the request has the HTTP method OPTIONS and contains the
 					// "Access-Control-Request-Method" header. If it does, then we treat
 					// it as a preflight request.
@@ -317,7 +351,7 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 						// set the necessary preflight response headers, as discussed
 						// previously.
 						w.Header().Set("Access-Control-Allow-Method", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, If-None-Match, X-Expected-Version")
 
 						// write the headers along with a 200 OK status and return from
 						// the middleware with no further action.
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"greenlight.mayuraandrew.tech/internal/data"
 	"greenlight.mayuraandrew.tech/internal/validator"
+	"mime"
 	"net/http"
-	"strconv"
+	"strings"
 )
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
@@ -30,6 +32,12 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 		Genres:  input.Genres,
 	}
 
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
 	// initialize a new Validator instance
 	v := validator.New()
 
@@ -57,12 +65,12 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	//// use the Valid() method to see if any of the checks failed. If they did, then use
 	//// the failedValidationResponse() helper to send a response to the client, passing
 	//// in the v.Errors map.
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
@@ -74,6 +82,7 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// interpolating the system-generated ID for our new movie in the URL.
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("ETag", movieETag(movie))
 
 	// write a JSON response with a 201 Created status code, the movie data in the
 	// response body, and the Location header.
@@ -91,6 +100,17 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// the optional fields query string parameter selects the fields of the movie to
+	// return, and include lists related data to embed in it, such as include=credits.
+	v := validator.New()
+
+	projection := app.readProjection(r.URL.Query(), data.MovieFields, data.MovieIncludes)
+
+	if data.ValidateProjection(v, projection); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	movie, err := app.models.Movies.Get(id)
 	if err != nil {
 		switch {
@@ -102,7 +122,39 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelop{"movie": movie}, nil)
+	err = app.includeMovieRelations([]*data.Movie{movie}, projection)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	projected, err := project(movie, projection)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	// if the client already has this representation of the movie, there's no need to
+	// send it again. Projections get their own weak tag.
+	etag := movieETag(movie)
+	if len(projection.Fields) > 0 || len(projection.Include) > 0 {
+		etag, err = projectionETag(movie, projected)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag, true) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"movie": projected}, headers)
 	// otherwise, interpolate the movie ID in a placeholder response.
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
@@ -132,49 +184,74 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// declare an input struct to hold the expected data from the client.
-
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
-	}
-
-	// read the JSON request body data into the input struct
-	err = app.readJSON(w, r, &input)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
+	// if the request contains an If-Match header, check that the client is updating the
+	// version of the movie they last fetched before making any changes. If someone else
+	// updates the movie after this check, Update() still returns an edit conflict.
+	if !app.checkIfMatch(w, r, movie) {
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
+	// as well as our own partial JSON format, where only the fields which are present
+	// are updated, clients can send a standard JSON Merge Patch or JSON Patch document.
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mergePatchMediaType, jsonPatchMediaType:
+		if !app.patchMovie(w, r, movie, mediaType) {
+			return
+		}
 
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
+	default:
+		// any other content type, including none at all, is read as our partial JSON
+		// format, as it always has been. Declare an input struct to hold the expected
+		// data from the client.
+		var input struct {
+			Title   *string       `json:"title"`
+			Year    *int32        `json:"year"`
+			Runtime *data.Runtime `json:"runtime"`
+			Genres  []string      `json:"genres"`
+		}
+
+		// read the JSON request body data into the input struct
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+
+		if input.Title != nil {
+			movie.Title = *input.Title
+		}
 
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
+		if input.Year != nil {
+			movie.Year = *input.Year
+		}
+
+		if input.Runtime != nil {
+			movie.Runtime = *input.Runtime
+		}
+
+		if input.Genres != nil {
+			movie.Genres = input.Genres // don't need to dereference a  slice
+		}
 	}
 
-	if input.Genres != nil {
-		movie.Genres = input.Genres // don't need to dereference a  slice
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
 	}
 
 	// validate the updated movie record, sending the client a 422 Unprocessble Entity
 	// response if any checks fail.
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
 	// pass the updated movie record to our new Update() method
-	err = app.models.Movies.Update(movie)
+	err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -185,17 +262,155 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// if the request contains a X-Expected-Version header, verify that the movie,
-	// version in the database matches the expected version specified in the header.
-	if r.Header.Get("X-Expected-Version") != "" {
-		if strconv.FormatInt(int64(movie.Version), 32) != r.Header.Get("x-Expected-Version") {
-			app.editConflictResponse(w, r)
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	// write the updated movie record in a JSON response.
+	err = app.writeJSON(w, http.StatusOK, envelop{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the replaceMovieHandler() handles "PUT /v1/movies/:id". Unlike PATCH, the body must
+// contain the complete movie, and any fields left out are validated as missing. If the
+// -movies-create-on-put flag is set and there is no movie with the ID, the movie is
+// created with that ID instead, so that a catalogue can be synced by repeating the same
+// requests. An If-None-Match: * header only allows the movie to be created, and an
+// If-Match header only allows an existing movie to be replaced.
+//
+// The body can be a movie exactly as returned by GET. Its id must match the URL, and
+// its version, if given, must be the movie's current version, like an If-Match header.
+// The other read-only fields, such as the ratings, are ignored.
+func (app *application) replaceMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ID      *int64       `json:"id"`
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+		Version *int32       `json:"version"`
+
+		// the read-only fields are read so that they aren't rejected as unknown, but
+		// are otherwise ignored.
+		AverageRating json.RawMessage `json:"average_rating"`
+		RatingCount   json.RawMessage `json:"rating_count"`
+		Poster        json.RawMessage `json:"poster"`
+		Backdrop      json.RawMessage `json:"backdrop"`
+		Credits       json.RawMessage `json:"credits"`
+		Releases      json.RawMessage `json:"releases"`
+		CreatedBy     json.RawMessage `json:"created_by"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.ID != nil && *input.ID != id {
+		v := validator.New()
+		v.AddError("id", "must match the movie ID in the URL")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			if !app.config.movies.createOnPut {
+				app.notFoundResponse(w, r)
+				return
+			}
+			movie = nil
+		default:
+			app.serverErrorRespone(w, r, err)
 			return
 		}
 	}
 
-	// write the updated movie record in a JSON response.
-	err = app.writeJSON(w, http.StatusOK, envelop{"movie": movie}, nil)
+	if movie == nil {
+		// If-Match and a version in the body require an existing movie.
+		if r.Header.Get("If-Match") != "" || input.Version != nil {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+		movie = &data.Movie{ID: id}
+	} else {
+		if r.Header.Get("If-None-Match") == "*" {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+		if !app.checkIfMatch(w, r, movie) {
+			return
+		}
+		if input.Version != nil && *input.Version != movie.Version {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+	}
+
+	movie.Title = input.Title
+	movie.Year = input.Year
+	movie.Runtime = input.Runtime
+	movie.Genres = input.Genres
+
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	headers := make(http.Header)
+	status := http.StatusOK
+
+	// a new movie has a zero version, as it hasn't been saved yet.
+	if movie.Version == 0 {
+		err = app.models.Movies.InsertWithID(movie, app.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrMovieInTrash):
+				app.errorResponse(w, r, http.StatusConflict, "the movie with this ID is in the trash, restore it before replacing it")
+			case errors.Is(err, data.ErrDuplicateMovieID):
+				app.errorResponse(w, r, http.StatusConflict, "this movie ID has been used before and can't be reused")
+			default:
+				app.serverErrorRespone(w, r, err)
+			}
+			return
+		}
+
+		headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+		status = http.StatusCreated
+	} else {
+		err = app.models.Movies.Update(movie, app.contextGetUser(r).ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrEditConflict):
+				app.editConflictResponse(w, r)
+			default:
+				app.serverErrorRespone(w, r, err)
+			}
+			return
+		}
+	}
+
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, status, envelop{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
@@ -209,12 +424,41 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// if the request contains an If-Match (or X-Expected-Version) header, fetch the
+	// movie and check that it hasn't changed since the client last fetched it. The
+	// version that was checked is passed on to Delete(), so that the movie isn't
+	// deleted if it is changed in the meantime.
+	var version int32
+
+	if r.Header.Get("If-Match") != "" || r.Header.Get("X-Expected-Version") != "" {
+		movie, err := app.models.Movies.Get(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorRespone(w, r, err)
+			}
+			return
+		}
+
+		if !app.checkIfMatch(w, r, movie) {
+			return
+		}
+
+		version = movie.Version
+	}
+
 	// delete the movie from the database, sending a 404 Not Found response to the client if there isn;t a matching record.
-	err = app.models.Movies.Delete(id)
+	err = app.models.Movies.Delete(id, app.contextGetUser(r).ID, version)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict) && r.Header.Get("If-Match") != "":
+			app.preconditionFailedResponse(w, r)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorRespone(w, r, err)
 		}
@@ -232,8 +476,7 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// this input struct will hold the expected values from the request query string.
 
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilters
 		data.Filters
 	}
 
@@ -249,6 +492,51 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.GenresMode = app.readString(qs, "genres_mode", "all")
+
+	// the year and runtime ranges are inclusive, and either end can be left open.
+	input.YearMin = int32(app.readInt(qs, "year_min", 0, v))
+	input.YearMax = int32(app.readInt(qs, "year_max", 0, v))
+	input.RuntimeMin = app.readRuntime(qs, "runtime_min", v)
+	input.RuntimeMax = app.readRuntime(qs, "runtime_max", v)
+
+	// the region filter lists the movies which have been released in a country, in any
+	// way, by today.
+	input.Region = strings.ToUpper(app.readString(qs, "region", ""))
+
+	// the watchlist and watched filters are applied to the authenticated user's own
+	// watchlist. They are left as nil if not provided, so that no filtering happens.
+	input.UserID = app.contextGetUser(r).ID
+	input.Watchlist = app.readBool(qs, "watchlist", v)
+	input.Watched = app.readBool(qs, "watched", v)
+
+	// the director and actor filters are person IDs.
+	input.DirectorID = int64(app.readInt(qs, "director", 0, v))
+	input.ActorID = int64(app.readInt(qs, "actor", 0, v))
+
+	v.Check(input.DirectorID >= 0, "director", "must be a positive integer")
+	v.Check(input.ActorID >= 0, "actor", "must be a positive integer")
+
+	// the collection filter lists the movies in a collection which the user can see,
+	// by default in the collection's order.
+	input.CollectionID = int64(app.readInt(qs, "collection", 0, v))
+	v.Check(input.CollectionID >= 0, "collection", "must be a positive integer")
+
+	defaultSort := "id"
+	if input.CollectionID > 0 {
+		defaultSort = "position"
+
+		collection, err := app.models.Collections.Get(input.CollectionID)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("collection", "must be an existing collection")
+		case err != nil:
+			app.serverErrorRespone(w, r, err)
+			return
+		case !collection.VisibleTo(app.contextGetUser(r).ID):
+			v.AddError("collection", "must be an existing collection")
+		}
+	}
 
 	// get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the validator instance
@@ -259,8 +547,47 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// extrat the sort query string value, falling back to "id" if it is not provided
 	// by the client (which will imply a ascending sort on movie ID).
 
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "rating", "relevance", "-id", "-title", "-year", "-runtime", "-rating", "-relevance"}
+
+	// sorting by position in a collection is only possible with the collection filter.
+	if input.CollectionID > 0 {
+		input.Filters.SortSafelist = append(input.Filters.SortSafelist, "position", "-position")
+	}
+
+	// if the client provides a cursor (from the next_cursor value of a previous
+	// response) then we switch to keyset pagination. The sort order is carried in the
+	// cursor, so the sort parameter can be omitted.
+	if cursor := qs.Get("cursor"); cursor != "" {
+		c, err := data.DecodeCursor(cursor, []byte(app.config.cursor.secret))
+		if err != nil {
+			v.AddError("cursor", "invalid cursor")
+		} else {
+			input.Filters.Cursor = c
+			input.Filters.Sort = app.readString(qs, "sort", c.Sort)
+		}
+		v.Check(qs.Get("page") == "", "page", "must not be provided with a cursor")
+	}
+
+	// the optional facets parameter lists the facet counts to include in the response,
+	// such as facets=genres,year.
+	facets := app.readCSV(qs, "facets", []string{})
+	for _, facet := range facets {
+		v.Check(validator.In(facet, data.MovieFacets...), "facets", "invalid facets value")
+	}
+	v.Check(validator.Unique(facets), "facets", "must not contain duplicate values")
+
+	// the fields and include parameters work in the same way as for a single movie.
+	projection := app.readProjection(qs, data.MovieFields, data.MovieIncludes)
+	data.ValidateProjection(v, projection)
+
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	data.ValidateMovieFilters(v, &input.MovieFilters, genres)
 
 	// check the validator instance for any errors and use the failedValidationResponse()
 	// helper to send the client a response if necessary.
@@ -271,15 +598,132 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 
 	// Call the GetAll() method to retrieve the movies,
 	// passing in the various filter parameters.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	movies, metadata, err := app.models.Movies.GetAll(input.MovieFilters, input.Filters)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	// sign the position of the last movie, if there are more to fetch.
+	if metadata.Next != nil {
+		metadata.NextCursor = data.EncodeCursor(*metadata.Next, []byte(app.config.cursor.secret))
+	}
+
+	err = app.includeMovieRelations(movies, projection)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 		return
 	}
 
+	projected := make([]any, len(movies))
+	for i, movie := range movies {
+		projected[i], err = project(movie, projection)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+	}
+
+	env := envelop{"movies": projected, "metadata": metadata}
+
+	if len(facets) > 0 {
+		env["facets"], err = app.models.Movies.Facets(input.MovieFilters, facets)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+	}
+
 	// send a JSON response containing the movie data and metadata
-	err = app.writeJSON(w, http.StatusOK, envelop{"movies": movies, "metadata": metadata}, nil)
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the includeMovieRelations() helper embeds the related data requested with include=
+// in the movies. Each kind of related data is fetched for all of the movies in one
+// query.
+func (app *application) includeMovieRelations(movies []*data.Movie, projection data.Projection) error {
+	if len(movies) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(movies))
+	for i, movie := range movies {
+		ids[i] = movie.ID
+	}
+
+	if projection.Includes("credits") {
+		credits, err := app.models.Credits.GetAllForMovies(ids)
+		if err != nil {
+			return err
+		}
+
+		for _, movie := range movies {
+			movie.Credits = credits[movie.ID]
+			if movie.Credits == nil {
+				movie.Credits = []*data.Credit{}
+			}
+		}
+	}
+
+	if projection.Includes("releases") {
+		releases, err := app.models.Releases.GetAllForMovies(ids)
+		if err != nil {
+			return err
+		}
+
+		for _, movie := range movies {
+			movie.Releases = releases[movie.ID]
+			if movie.Releases == nil {
+				movie.Releases = []*data.Release{}
+			}
+		}
+	}
+
+	if projection.Includes("created_by") {
+		creators, err := app.models.Revisions.GetCreators(ids)
+		if err != nil {
+			return err
+		}
+
+		for _, movie := range movies {
+			movie.CreatedBy = creators[movie.ID]
+		}
+	}
+
+	return nil
+}
+
+// the autocompleteMoviesHandler() returns title suggestions for a partially typed
+// search, for the "GET /v1/movies/autocomplete?q=" endpoint.
+func (app *application) autocompleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	q := app.readString(qs, "q", "")
+	limit := app.readInt(qs, "limit", 10, v)
+
+	v.Check(q != "", "q", "must be provided")
+	v.Check(len(q) <= 500, "q", "must not be more than 500 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Autocomplete(q, limit)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
 
+	err = app.writeJSON(w, http.StatusOK, envelop{"suggestions": suggestions}, nil)
 	if err != nil {
 		app.serverErrorRespone(w, r, err)
 	}
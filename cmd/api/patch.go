@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// the media types for the two standard patch formats accepted by PATCH /v1/movies/:id.
+const (
+	mergePatchMediaType = "application/merge-patch+json"
+	jsonPatchMediaType  = "application/json-patch+json"
+)
+
+// errPatchTestFailed is returned by applyJSONPatch() when a "test" operation doesn't
+// match the document.
+var errPatchTestFailed = errors.New("test operation failed")
+
+// a movieDocument is the JSON document that a movie patch is applied to. It contains
+// the editable fields of a movie, plus the read-only id and version so that a JSON
+// Patch can "test" them.
+type movieDocument struct {
+	ID      int64        `json:"id"`
+	Title   string       `json:"title"`
+	Year    int32        `json:"year"`
+	Runtime data.Runtime `json:"runtime"`
+	Genres  []string     `json:"genres"`
+	Version int32        `json:"version"`
+}
+
+// a jsonPatchOperation is a single operation in an RFC 6902 JSON Patch document. The
+// "from" member is only read so that the "move" and "copy" operations can be reported
+// as unsupported, rather than as unknown keys.
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// the patchMovie() helper reads a merge patch or JSON patch from the request body
+// (depending on mediaType) and applies it to the movie. If the patch can't be read or
+// applied it sends the appropriate error response and returns false. The patched movie
+// still needs to be validated by the caller.
+func (app *application) patchMovie(w http.ResponseWriter, r *http.Request, movie *data.Movie, mediaType string) bool {
+	document, err := movieToDocument(movie)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return false
+	}
+
+	switch mediaType {
+	case mergePatchMediaType:
+		var patch map[string]any
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return false
+		}
+
+		document = applyMergePatch(document, patch)
+
+	case jsonPatchMediaType:
+		var patch []jsonPatchOperation
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return false
+		}
+
+		document, err = applyJSONPatch(document, patch)
+		if err != nil {
+			switch {
+			case errors.Is(err, errPatchTestFailed):
+				app.errorResponse(w, r, http.StatusConflict, err.Error())
+			default:
+				app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+			}
+			return false
+		}
+	}
+
+	// convert the patched document back into the movie. Any members which aren't part
+	// of a movie, or which have the wrong type, are reported in the same way as they
+	// are for a normal JSON body.
+	js, err := json.Marshal(document)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return false
+	}
+
+	var patched movieDocument
+
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&patched)
+	if err != nil {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("the patched movie is invalid: %s", err))
+		return false
+	}
+
+	if patched.ID != movie.ID || patched.Version != movie.Version {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "the id and version of a movie cannot be changed")
+		return false
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+
+	return true
+}
+
+// movieToDocument() converts a movie into the generic JSON value (as produced by
+// json.Unmarshal into an any) that patches are applied to.
+func movieToDocument(movie *data.Movie) (any, error) {
+	js, err := json.Marshal(movieDocument{
+		ID:      movie.ID,
+		Title:   movie.Title,
+		Year:    movie.Year,
+		Runtime: movie.Runtime,
+		Genres:  movie.Genres,
+		Version: movie.Version,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var document any
+
+	err = json.Unmarshal(js, &document)
+	return document, err
+}
+
+// applyMergePatch() applies an RFC 7396 JSON Merge Patch to the target document: the
+// members of the patch replace those in the target, objects are merged recursively,
+// and members set to null are removed. Arrays are always replaced as a whole.
+func applyMergePatch(target any, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+			continue
+		}
+		targetObject[key] = applyMergePatch(targetObject[key], value)
+	}
+
+	return targetObject
+}
+
+// applyJSONPatch() applies the operations of an RFC 6902 JSON Patch to the document in
+// order. The add, remove, replace and test operations are supported. If any operation
+// fails the whole patch fails, and patchMovie() leaves the movie unchanged.
+func applyJSONPatch(document any, patch []jsonPatchOperation) (any, error) {
+	for i, operation := range patch {
+		var err error
+
+		switch operation.Op {
+		case "add", "replace", "test":
+			if len(operation.Value) == 0 {
+				return nil, fmt.Errorf("operation %d (%s) must have a value", i, operation.Op)
+			}
+
+			var value any
+
+			err = json.Unmarshal(operation.Value, &value)
+			if err != nil {
+				return nil, fmt.Errorf("operation %d (%s) has an invalid value: %w", i, operation.Op, err)
+			}
+
+			switch operation.Op {
+			case "add":
+				document, err = patchPointer(document, operation.Path, func(parent any, key string) (any, error) {
+					return patchAdd(parent, key, value)
+				})
+			case "replace":
+				document, err = patchPointer(document, operation.Path, func(parent any, key string) (any, error) {
+					return patchReplace(parent, key, value)
+				})
+			case "test":
+				var current any
+
+				current, err = patchGet(document, operation.Path)
+				if err == nil && !reflect.DeepEqual(current, value) {
+					return nil, fmt.Errorf("%w: %q does not match the value", errPatchTestFailed, operation.Path)
+				}
+			}
+
+		case "remove":
+			document, err = patchPointer(document, operation.Path, patchRemove)
+
+		case "move", "copy":
+			return nil, fmt.Errorf("operation %d (%s) is not supported", i, operation.Op)
+
+		default:
+			return nil, fmt.Errorf("operation %d has an unknown op %q", i, operation.Op)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, operation.Op, err)
+		}
+	}
+
+	return document, nil
+}
+
+// parsePointer() splits an RFC 6901 JSON Pointer into its unescaped reference tokens.
+// The empty pointer refers to the whole document and has no tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must start with a slash", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(tokens[i], "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// patchGet() returns the value in the document that the pointer refers to.
+func patchGet(document any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	current := document
+	for _, token := range tokens {
+		switch container := current.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q does not exist", pointer)
+			}
+			current = value
+		case []any:
+			index, err := arrayIndex(token, len(container))
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %w", pointer, err)
+			}
+			current = container[index]
+		default:
+			return nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+	}
+
+	return current, nil
+}
+
+// patchPointer() finds the parent of the value that the pointer refers to, and calls
+// fn to change the parent, replacing it in the document with the value fn returns.
+// This is needed because appending to or removing from a slice makes a new slice.
+func patchPointer(document any, pointer string, fn func(parent any, key string) (any, error)) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("the whole movie cannot be added, removed or replaced")
+	}
+
+	var walk func(current any, tokens []string) (any, error)
+	walk = func(current any, tokens []string) (any, error) {
+		if len(tokens) == 1 {
+			return fn(current, tokens[0])
+		}
+
+		switch container := current.(type) {
+		case map[string]any:
+			child, ok := container[tokens[0]]
+			if !ok {
+				return nil, fmt.Errorf("path %q does not exist", pointer)
+			}
+			child, err := walk(child, tokens[1:])
+			if err != nil {
+				return nil, err
+			}
+			container[tokens[0]] = child
+			return container, nil
+		case []any:
+			index, err := arrayIndex(tokens[0], len(container))
+			if err != nil {
+				return nil, fmt.Errorf("path %q: %w", pointer, err)
+			}
+			child, err := walk(container[index], tokens[1:])
+			if err != nil {
+				return nil, err
+			}
+			container[index] = child
+			return container, nil
+		default:
+			return nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+	}
+
+	return walk(document, tokens)
+}
+
+// patchAdd() adds a member to an object, or inserts an element into an array (where
+// the key "-" appends to the end of the array).
+func patchAdd(parent any, key string, value any) (any, error) {
+	switch container := parent.(type) {
+	case map[string]any:
+		container[key] = value
+		return container, nil
+	case []any:
+		index := len(container)
+		if key != "-" {
+			var err error
+
+			index, err = arrayIndex(key, len(container)+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		container = append(container, nil)
+		copy(container[index+1:], container[index:])
+		container[index] = value
+		return container, nil
+	default:
+		return nil, fmt.Errorf("cannot add %q to a value which is not an object or array", key)
+	}
+}
+
+// patchRemove() removes a member from an object, or an element from an array. The
+// member or element must exist.
+func patchRemove(parent any, key string) (any, error) {
+	switch container := parent.(type) {
+	case map[string]any:
+		if _, ok := container[key]; !ok {
+			return nil, fmt.Errorf("%q does not exist", key)
+		}
+		delete(container, key)
+		return container, nil
+	case []any:
+		index, err := arrayIndex(key, len(container))
+		if err != nil {
+			return nil, err
+		}
+		return append(container[:index], container[index+1:]...), nil
+	default:
+		return nil, fmt.Errorf("%q does not exist", key)
+	}
+}
+
+// patchReplace() replaces the value of an existing object member or array element.
+func patchReplace(parent any, key string, value any) (any, error) {
+	switch container := parent.(type) {
+	case map[string]any:
+		if _, ok := container[key]; !ok {
+			return nil, fmt.Errorf("%q does not exist", key)
+		}
+		container[key] = value
+		return container, nil
+	case []any:
+		index, err := arrayIndex(key, len(container))
+		if err != nil {
+			return nil, err
+		}
+		container[index] = value
+		return container, nil
+	default:
+		return nil, fmt.Errorf("%q does not exist", key)
+	}
+}
+
+// arrayIndex() parses an array index from a JSON Pointer token, checking that it is
+// less than n. Leading zeros aren't allowed by RFC 6901.
+func arrayIndex(token string, n int) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if index >= n {
+		return 0, fmt.Errorf("array index %d is out of range", index)
+	}
+
+	return index, nil
+}
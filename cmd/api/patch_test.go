@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// decodeJSON unmarshals a JSON string into the generic value that patches work on.
+func decodeJSON(t *testing.T, s string) any {
+	t.Helper()
+
+	var value any
+
+	err := json.Unmarshal([]byte(s), &value)
+	if err != nil {
+		t.Fatalf("invalid JSON %q: %s", s, err)
+	}
+	return value
+}
+
+// decodePatch unmarshals a JSON Patch document.
+func decodePatch(t *testing.T, s string) []jsonPatchOperation {
+	t.Helper()
+
+	var patch []jsonPatchOperation
+
+	err := json.Unmarshal([]byte(s), &patch)
+	if err != nil {
+		t.Fatalf("invalid JSON Patch %q: %s", s, err)
+	}
+	return patch
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		name    string
+		pointer string
+		want    []string
+		wantErr bool
+	}{
+		{name: "Whole document", pointer: "", want: nil},
+		{name: "Empty key", pointer: "/", want: []string{""}},
+		{name: "Nested", pointer: "/genres/0", want: []string{"genres", "0"}},
+		{name: "Escaped slash", pointer: "/a~1b", want: []string{"a/b"}},
+		{name: "Escaped tilde", pointer: "/m~0n", want: []string{"m~n"}},
+		{name: "Tilde before one", pointer: "/~01", want: []string{"~1"}},
+		{name: "No leading slash", pointer: "title", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePointer(tt.pointer)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %q; want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArrayIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		n       int
+		want    int
+		wantErr bool
+	}{
+		{name: "First", token: "0", n: 2, want: 0},
+		{name: "Last", token: "1", n: 2, want: 1},
+		{name: "Out of range", token: "2", n: 2, wantErr: true},
+		{name: "Empty array", token: "0", n: 0, wantErr: true},
+		{name: "Leading zero", token: "01", n: 5, wantErr: true},
+		{name: "Negative", token: "-1", n: 5, wantErr: true},
+		{name: "Append marker", token: "-", n: 5, wantErr: true},
+		{name: "Not a number", token: "x", n: 5, wantErr: true},
+		{name: "Empty", token: "", n: 5, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := arrayIndex(tt.token, tt.n)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %d; want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	const movie = `{"id": 1, "title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"], "version": 3}`
+
+	tests := []struct {
+		name         string
+		document     string
+		patch        string
+		want         string
+		wantErr      bool
+		wantTestFail bool
+	}{
+		{
+			name:  "Replace title",
+			patch: `[{"op": "replace", "path": "/title", "value": "Moana 2"}]`,
+			want:  `{"id": 1, "title": "Moana 2", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure"], "version": 3}`,
+		},
+		{
+			name:  "Append genre",
+			patch: `[{"op": "add", "path": "/genres/-", "value": "comedy"}]`,
+			want:  `{"id": 1, "title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure", "comedy"], "version": 3}`,
+		},
+		{
+			name:  "Insert genre",
+			patch: `[{"op": "add", "path": "/genres/0", "value": "comedy"}]`,
+			want:  `{"id": 1, "title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["comedy", "animation", "adventure"], "version": 3}`,
+		},
+		{
+			name:  "Add at end index",
+			patch: `[{"op": "add", "path": "/genres/2", "value": "comedy"}]`,
+			want:  `{"id": 1, "title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["animation", "adventure", "comedy"], "version": 3}`,
+		},
+		{
+			name:  "Remove genre",
+			patch: `[{"op": "remove", "path": "/genres/0"}]`,
+			want:  `{"id": 1, "title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["adventure"], "version": 3}`,
+		},
+		{
+			name:  "Remove member",
+			patch: `[{"op": "remove", "path": "/year"}]`,
+			want:  `{"id": 1, "title": "Moana", "runtime": "107 mins", "genres": ["animation", "adventure"], "version": 3}`,
+		},
+		{
+			name:  "Several operations",
+			patch: `[{"op": "test", "path": "/version", "value": 3}, {"op": "remove", "path": "/genres/1"}, {"op": "replace", "path": "/genres/0", "value": "drama"}]`,
+			want:  `{"id": 1, "title": "Moana", "year": 2016, "runtime": "107 mins", "genres": ["drama"], "version": 3}`,
+		},
+		{
+			name:     "Escaped pointers",
+			document: `{"a/b": 1, "m~n": 2}`,
+			patch:    `[{"op": "replace", "path": "/a~1b", "value": 3}, {"op": "replace", "path": "/m~0n", "value": 4}]`,
+			want:     `{"a/b": 3, "m~n": 4}`,
+		},
+		{
+			name:     "Nested objects",
+			document: `{"a": {"b": [{"c": 1}]}}`,
+			patch:    `[{"op": "add", "path": "/a/b/0/d", "value": null}, {"op": "replace", "path": "/a/b/0/c", "value": {"e": true}}]`,
+			want:     `{"a": {"b": [{"c": {"e": true}, "d": null}]}}`,
+		},
+		{
+			name:  "Test array",
+			patch: `[{"op": "test", "path": "/genres", "value": ["animation", "adventure"]}]`,
+			want:  movie,
+		},
+		{
+			name:  "Test number",
+			patch: `[{"op": "test", "path": "/year", "value": 2016}]`,
+			want:  movie,
+		},
+		{
+			name:         "Test mismatch",
+			patch:        `[{"op": "test", "path": "/genres", "value": ["adventure", "animation"]}]`,
+			wantTestFail: true,
+		},
+		{
+			name:         "Test type mismatch",
+			patch:        `[{"op": "test", "path": "/year", "value": "2016"}]`,
+			wantTestFail: true,
+		},
+		{
+			name:    "Test missing path",
+			patch:   `[{"op": "test", "path": "/rating", "value": 1}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Add out of range",
+			patch:   `[{"op": "add", "path": "/genres/3", "value": "comedy"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Replace out of range",
+			patch:   `[{"op": "replace", "path": "/genres/2", "value": "comedy"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Replace append marker",
+			patch:   `[{"op": "replace", "path": "/genres/-", "value": "comedy"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Remove out of range",
+			patch:   `[{"op": "remove", "path": "/genres/2"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Remove missing member",
+			patch:   `[{"op": "remove", "path": "/rating"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Replace missing member",
+			patch:   `[{"op": "replace", "path": "/rating", "value": 5}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Missing parent",
+			patch:   `[{"op": "add", "path": "/credits/0", "value": 1}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Index into a string",
+			patch:   `[{"op": "replace", "path": "/title/0", "value": "m"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Whole document",
+			patch:   `[{"op": "replace", "path": "", "value": {}}]`,
+			wantErr: true,
+		},
+		{
+			name:    "No leading slash",
+			patch:   `[{"op": "replace", "path": "title", "value": "Moana 2"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Missing value",
+			patch:   `[{"op": "add", "path": "/title"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Move",
+			patch:   `[{"op": "move", "from": "/title", "path": "/name"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Copy",
+			patch:   `[{"op": "copy", "from": "/title", "path": "/name"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown op",
+			patch:   `[{"op": "increment", "path": "/year", "value": 1}]`,
+			wantErr: true,
+		},
+		{
+			name:    "Fails after earlier operations",
+			patch:   `[{"op": "replace", "path": "/title", "value": "Moana 2"}, {"op": "remove", "path": "/rating"}]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			document := tt.document
+			if document == "" {
+				document = movie
+			}
+
+			got, err := applyJSONPatch(decodeJSON(t, document), decodePatch(t, tt.patch))
+
+			switch {
+			case tt.wantTestFail:
+				if !errors.Is(err, errPatchTestFailed) {
+					t.Fatalf("got error %v; want %v", err, errPatchTestFailed)
+				}
+				return
+			case tt.wantErr:
+				if err == nil {
+					t.Fatal("got no error; want one")
+				}
+				if errors.Is(err, errPatchTestFailed) {
+					t.Fatalf("got %v; want an error other than a failed test", err)
+				}
+				return
+			case err != nil:
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	// the cases are based on the examples in appendix A of RFC 7396.
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{name: "Replace member", target: `{"a": "b"}`, patch: `{"a": "c"}`, want: `{"a": "c"}`},
+		{name: "Add member", target: `{"a": "b"}`, patch: `{"b": "c"}`, want: `{"a": "b", "b": "c"}`},
+		{name: "Remove member", target: `{"a": "b"}`, patch: `{"a": null}`, want: `{}`},
+		{name: "Remove one of two", target: `{"a": "b", "b": "c"}`, patch: `{"a": null}`, want: `{"b": "c"}`},
+		{name: "Remove missing member", target: `{"a": "b"}`, patch: `{"c": null}`, want: `{"a": "b"}`},
+		{name: "Replace array", target: `{"a": ["b"]}`, patch: `{"a": "c"}`, want: `{"a": "c"}`},
+		{name: "Replace with array", target: `{"a": "c"}`, patch: `{"a": ["b"]}`, want: `{"a": ["b"]}`},
+		{name: "Merge nested", target: `{"a": {"b": "c"}}`, patch: `{"a": {"b": "d", "c": null}}`, want: `{"a": {"b": "d"}}`},
+		{name: "Array of objects", target: `{"a": [{"b": "c"}]}`, patch: `{"a": [1]}`, want: `{"a": [1]}`},
+		{name: "Array target", target: `["a", "b"]`, patch: `["c", "d"]`, want: `["c", "d"]`},
+		{name: "Array over object", target: `{"a": "b"}`, patch: `["c"]`, want: `["c"]`},
+		{name: "Null patch", target: `{"a": "foo"}`, patch: `null`, want: `null`},
+		{name: "String patch", target: `{"a": "foo"}`, patch: `"bar"`, want: `"bar"`},
+		{name: "Keep null values in target", target: `{"e": null}`, patch: `{"a": 1}`, want: `{"e": null, "a": 1}`},
+		{name: "Object over array", target: `[1, 2]`, patch: `{"a": "b", "c": null}`, want: `{"a": "b"}`},
+		{name: "Nested null removal", target: `{}`, patch: `{"a": {"bb": {"ccc": null}}}`, want: `{"a": {"bb": {}}}`},
+		{
+			name:   "Movie",
+			target: `{"id": 1, "title": "Moana", "year": 2016, "genres": ["animation", "adventure"], "version": 3}`,
+			patch:  `{"title": "Moana 2", "year": null, "genres": ["animation"]}`,
+			want:   `{"id": 1, "title": "Moana 2", "genres": ["animation"], "version": 3}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := applyMergePatch(decodeJSON(t, tt.target), decodeJSON(t, tt.patch))
+
+			if want := decodeJSON(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"net/http"
+	"strings"
+)
+
+func (app *application) listReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	releases, err := app.models.Releases.GetAllForMovie(movieID)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"releases": releases}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) createReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Country       string `json:"country"`
+		Type          string `json:"type"`
+		Date          string `json:"date"`
+		Certification string `json:"certification"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	// country codes are stored in upper case, but accepted in either.
+	release := &data.Release{
+		MovieID:       movieID,
+		Country:       strings.ToUpper(input.Country),
+		Type:          input.Type,
+		Date:          input.Date,
+		Certification: input.Certification,
+	}
+
+	v := validator.New()
+
+	if data.ValidateRelease(v, release); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Releases.Insert(release)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateRelease):
+			v.AddError("type", "a release of this type already exists for the country")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelop{"release": release}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) deleteReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	releaseID, err := app.readNamedIDParam(r, "release_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Releases.Delete(movieID, releaseID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "release successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
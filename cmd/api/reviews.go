@@ -0,0 +1,277 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"net/http"
+)
+
+func (app *application) createReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Rating int32  `json:"rating"`
+		Body   string `json:"body"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// make sure that the movie exists before accepting a review for it.
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	review := &data.Review{
+		MovieID: movieID,
+		UserID:  app.contextGetUser(r).ID,
+		Rating:  input.Rating,
+		Body:    input.Body,
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Insert(review)
+	if err != nil {
+		switch {
+		// a user can only review each movie once, so report a duplicate review in the
+		// same way as a duplicate email address.
+		case errors.Is(err, data.ErrDuplicateReview):
+			v.AddError("review", "you have already reviewed this movie")
+			app.failedValidationResponse(w, r, v.Errors)
+		// the movie was moved to the trash after we looked it up.
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/movies/%d/reviews/%d", movieID, review.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelop{"review": review}, headers)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) listReviewsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafelist = []string{"id", "created_at", "rating", "-id", "-created_at", "-rating"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	reviews, metadata, err := app.models.Reviews.GetAllForMovie(movieID, input.Filters)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"reviews": reviews, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) showReviewHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	reviewID, err := app.readNamedIDParam(r, "review_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	review, err := app.models.Reviews.Get(movieID, reviewID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"review": review}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) updateReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readEditableReview(w, r)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Rating *int32  `json:"rating"`
+		Body   *string `json:"body"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Rating != nil {
+		review.Rating = *input.Rating
+	}
+
+	if input.Body != nil {
+		review.Body = *input.Body
+	}
+
+	v := validator.New()
+
+	if data.ValidateReview(v, review); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Reviews.Update(review)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"review": review}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) deleteReviewHandler(w http.ResponseWriter, r *http.Request) {
+	review, ok := app.readEditableReview(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.Reviews.Delete(review.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "review successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// readEditableReview() fetches the review identified by the URL parameters and checks
+// that the current user is allowed to change it: either they wrote the review, or they
+// hold the "reviews:moderate" permission. If not, an error response has already been
+// sent and the boolean return value is false.
+func (app *application) readEditableReview(w http.ResponseWriter, r *http.Request) (*data.Review, bool) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	reviewID, err := app.readNamedIDParam(r, "review_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil, false
+	}
+
+	review, err := app.models.Reviews.Get(movieID, reviewID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return nil, false
+	}
+
+	user := app.contextGetUser(r)
+	if review.UserID == user.ID {
+		return review, true
+	}
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return nil, false
+	}
+
+	if !permissions.Include("reviews:moderate") {
+		app.notPermittedResponse(w, r)
+		return nil, false
+	}
+
+	return review, true
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"net/http"
+)
+
+// readVersionParam() reads the ":version" URL parameter. Like readIDParam(), it returns
+// an error if the value isn't a positive integer.
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	version, err := app.readNamedIDParam(r, "version")
+	if err != nil || version > 1<<31-1 {
+		return 0, errors.New("invalid version parameter")
+	}
+	return int32(version), nil
+}
+
+// the listMovieRevisionsHandler() handles "GET /v1/movies/:id/revisions". The history
+// of a movie is still available after it has been deleted.
+func (app *application) listMovieRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	// the newest revisions are listed first by default.
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+	input.Filters.SortSafelist = []string{"version", "-version"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Revisions.GetAllForMovie(movieID, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"revisions": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the showMovieRevisionHandler() handles "GET /v1/movies/:id/revisions/:version".
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Revisions.Get(movieID, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the restoreMovieRevisionHandler() handles "POST /v1/movies/:id/revisions/:version/restore".
+// It rolls a movie back to the fields it had at an earlier version. The rollback is
+// saved as a new version (recorded with the "restore" action), so the history is never
+// rewritten and the rollback can itself be undone.
+func (app *application) restoreMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	// as with updates, the client can make sure that nobody else has changed the movie
+	// since they looked at its history.
+	if !app.checkIfMatch(w, r, movie) {
+		return
+	}
+
+	revision, err := app.models.Revisions.Get(movieID, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	movie.Title = revision.Title
+	movie.Year = revision.Year
+	movie.Runtime = revision.Runtime
+	movie.Genres = revision.Genres
+
+	// the genres catalogue may have changed since the revision was saved, so the
+	// restored fields are validated like any other update. Genres which have since been
+	// renamed or merged are replaced with their current names, but a genre which has
+	// been deleted makes the rollback fail.
+	genres, err := app.models.Genres.Catalogue()
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateMovie(v, movie, genres); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Rollback(movie, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
@@ -24,14 +24,86 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id", app.staticSegments("id", map[string]http.HandlerFunc{
+		"import": app.requirePermission("movies:write", app.importMoviesHandler),
+	}, app.methodNotAllowedResponse))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.staticSegments("id", map[string]http.HandlerFunc{
+		"autocomplete": app.requirePermission("movies:read", app.autocompleteMoviesHandler),
+		"export":       app.requirePermission("movies:export", app.exportMoviesHandler),
+		"trash":        app.requirePermission("movies:write", app.listTrashedMoviesHandler),
+	}, app.requirePermission("movies:read", app.showMovieHandler)))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.requirePermission("movies:write", app.replaceMovieHandler))
 	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.putMovieImageHandler("poster")))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/poster", app.requirePermission("movies:write", app.deleteMovieImageHandler("poster")))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/backdrop", app.requirePermission("movies:write", app.putMovieImageHandler("backdrop")))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/backdrop", app.requirePermission("movies:write", app.deleteMovieImageHandler("backdrop")))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.listReviewsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requirePermission("movies:read", app.createReviewHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.showReviewHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.updateReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews/:review_id", app.requirePermission("movies:read", app.deleteReviewHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.requirePermission("movies:read", app.listCreditsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requirePermission("movies:write", app.createCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requirePermission("movies:write", app.deleteCreditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/releases", app.requirePermission("movies:read", app.listReleasesHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/releases", app.requirePermission("movies:write", app.createReleaseHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/releases/:release_id", app.requirePermission("movies:write", app.deleteReleaseHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions", app.requirePermission("movies:read", app.listMovieRevisionsHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/revisions/:version", app.requirePermission("movies:read", app.showMovieRevisionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revisions/:version/restore", app.requirePermission("movies:write", app.restoreMovieRevisionHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.requirePermission("movies:read", app.listPeopleHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requirePermission("movies:write", app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.requirePermission("movies:read", app.showPersonHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requirePermission("movies:write", app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requirePermission("movies:write", app.deletePersonHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.requirePermission("movies:read", app.listGenresHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requirePermission("genres:write", app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:id", app.requirePermission("movies:read", app.showGenreHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requirePermission("genres:write", app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:id", app.requirePermission("genres:write", app.deleteGenreHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/genres/:id/merge", app.requirePermission("genres:write", app.mergeGenreHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.requirePermission("movies:read", app.listCollectionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requirePermission("movies:read", app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.requirePermission("movies:read", app.showCollectionHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requirePermission("movies:read", app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requirePermission("movies:read", app.deleteCollectionHandler))
 
 	// route for the POST /v1/users endpoint
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/email", app.confirmEmailChangeHandler)
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication/all", app.requireAuthenticatedUser(app.deleteAllAuthenticationTokensHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me", app.requireAuthenticatedUser(app.showCurrentUserHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/users/me", app.requireAuthenticatedUser(app.updateCurrentUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/password", app.requireAuthenticatedUser(app.updateCurrentUserPasswordHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/email", app.requireAuthenticatedUser(app.updateCurrentUserEmailHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireAuthenticatedUser(app.listCurrentUserSessionsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/watchlist/:movie_id", app.requireActivatedUser(app.showWatchlistEntryHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/users/me/watchlist/:movie_id", app.requireActivatedUser(app.setWatchlistEntryHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/watchlist/:movie_id", app.requireActivatedUser(app.deleteWatchlistEntryHandler))
+
+	// serve uploaded images ourselves when they are stored in a local directory.
+	if app.config.storage.backend == "local" {
+		router.Handler(http.MethodGet, "/v1/images/*filepath", http.StripPrefix("/v1/images", app.serveImages(http.Dir(app.config.storage.dir))))
+	}
 
 	// Register a new GET /debug/vars endpoint pointing to the expvar handler.
 
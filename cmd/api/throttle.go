@@ -0,0 +1,47 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// A throttle allows an action at most once per interval for each key, such as sending
+// an email to an address. Unlike the rateLimit() middleware, which limits the requests
+// from each client, it limits how often something happens to a target, whoever asks
+// for it. The state is kept in memory, so each instance of the API has its own.
+type throttle struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newThrottle(interval time.Duration) *throttle {
+	return &throttle{
+		interval: interval,
+		last:     make(map[string]time.Time),
+	}
+}
+
+// allow() reports whether the action is allowed for the key now, and if so records
+// that it has happened.
+func (t *throttle) allow(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+
+	// remove the keys whose interval has passed, so that the map doesn't keep growing.
+	for k, last := range t.last {
+		if now.Sub(last) >= t.interval {
+			delete(t.last, k)
+		}
+	}
+
+	if _, found := t.last[key]; found {
+		return false
+	}
+
+	t.last[key] = now
+	return true
+}
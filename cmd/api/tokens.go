@@ -5,6 +5,7 @@ import (
 	"greenlight.mayuraandrew.tech/internal/data"
 	"greenlight.mayuraandrew.tech/internal/validator"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -76,3 +77,171 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		app.serverErrorRespone(w, r, err)
 	}
 }
+
+// the createPasswordResetTokenHandler() handles "POST /v1/tokens/password-reset". It
+// emails a short-lived password reset token to the user with the given address. The
+// response is the same whether or not there is such a user, so that it can't be used
+// to find out which email addresses have accounts. As with activation emails, only one
+// reset email is sent to an address every few minutes.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.passwordResetThrottle.allow(strings.ToLower(input.Email)) {
+		app.errorResponse(w, r, http.StatusTooManyRequests, "a password reset email was requested for this address recently, please try again later")
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	if user != nil {
+		// only the newest reset token is valid.
+		err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"passwordResetToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	env := envelop{"message": "if there is an account with this email address, you will receive an email with password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the createActivationTokenHandler() handles "POST /v1/tokens/activation", which sends a
+// new activation token to a user who hasn't activated their account yet, replacing any
+// earlier ones. Like the password reset endpoint, the response doesn't reveal whether
+// there is such a user. Emails are throttled per address, whether or not there is an
+// account for it, so that the endpoint can't be used to flood an inbox.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	if !app.activationThrottle.allow(strings.ToLower(input.Email)) {
+		app.errorResponse(w, r, http.StatusTooManyRequests, "an activation email was requested for this address recently, please try again later")
+		return
+	}
+
+	user, err := app.models.Users.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	if user != nil && !user.Activated {
+		err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+
+		token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+
+		app.background(func() {
+			data := map[string]any{
+				"activationToken": token.Plaintext,
+			}
+
+			err := app.mailer.Send(user.Email, "token_activation.tmpl", data)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+			}
+		})
+	}
+
+	env := envelop{"message": "if there is an inactive account with this email address, you will receive an email with activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the deleteAuthenticationTokenHandler() handles "DELETE /v1/tokens/authentication",
+// logging the user out by revoking the token that the request was authenticated with.
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.models.Tokens.Delete(data.ScopeAuthentication, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the deleteAllAuthenticationTokensHandler() handles "DELETE /v1/tokens/authentication/all",
+// which revokes every authentication token of the user, including the one used for the
+// request, logging them out of all of their sessions.
+func (app *application) deleteAllAuthenticationTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "you have been logged out of all sessions"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
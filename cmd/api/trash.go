@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// the listTrashedMoviesHandler() handles "GET /v1/movies/trash". It lists the movies
+// which have been deleted but not yet purged, most recently deleted first.
+func (app *application) listTrashedMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafelist = []string{"id", "title", "deleted_at", "-id", "-title", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.GetTrash(input.Filters)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the restoreMovieHandler() handles "POST /v1/movies/:id/restore". It takes a movie
+// back out of the trash, and responds with the restored movie.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Movies.Restore(id, app.contextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"movie": movie}, headers)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// purgeTrash() runs for the lifetime of the application, permanently deleting the
+// movies which have been in the trash for longer than the configured retention period,
+// along with the files of their posters and backdrops. It checks once at startup and
+// then every hour.
+func (app *application) purgeTrash() {
+	for {
+		func() {
+			// recover any panic, so that a failed purge doesn't bring down the server.
+			defer func() {
+				if err := recover(); err != nil {
+					app.logger.PrintError(fmt.Errorf("%s", err), nil)
+				}
+			}()
+
+			purged, images, err := app.models.Movies.PurgeTrash(app.config.trash.retention)
+			if err != nil {
+				app.logger.PrintError(err, nil)
+				return
+			}
+
+			for _, img := range images {
+				app.deleteImageFiles(img)
+			}
+
+			if purged > 0 {
+				app.logger.PrintInfo("purged trashed movies", map[string]string{
+					"count": strconv.FormatInt(purged, 10),
+				})
+			}
+		}()
+
+		time.Sleep(time.Hour)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"greenlight.mayuraandrew.tech/internal/data"
@@ -106,7 +107,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		// Send the welcome email
 		
 
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+		err := app.mailer.Send(user.Email, "user_welcome.tmpl", data)
 		if err != nil {
 			app.logger.PrintError(err, nil)
 		}
@@ -198,3 +199,408 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		app.serverErrorRespone(w, r, err)
 	}
 }
+
+// the showCurrentUserHandler() handles "GET /v1/users/me", returning the account of the
+// authenticated user along with their permissions.
+func (app *application) showCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"user": user, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the updateCurrentUserHandler() handles "PATCH /v1/users/me". Only the name can be
+// changed here; the user record was read by the authenticate middleware, so its
+// version number catches any change made to the account since then.
+func (app *application) updateCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		user.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateUser(v, user); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"user": user}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the updateCurrentUserPasswordHandler() handles "PUT /v1/users/me/password". The
+// current password must be given as well as the new one, so that a stolen token alone
+// isn't enough to take over the account. Every other authentication token of the user
+// is revoked, signing out their other sessions, while the token used for this request
+// stays valid. Any outstanding password reset and email change tokens are revoked too.
+func (app *application) updateCurrentUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		Password        string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.CurrentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, input.Password)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.CurrentPassword)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	// the email change tokens are revoked below, so a pending email can no longer be
+	// confirmed.
+	user.PendingEmail = ""
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUserExcept(data.ScopeAuthentication, user.ID, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	for _, scope := range []string{data.ScopePasswordReset, data.ScopeEmailChange} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "password successfully changed"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the updateUserPasswordHandler() handles "PUT /v1/users/password", which sets a new
+// password using a token from a password reset email. Once the password has been
+// reset, the user's reset tokens are used up and all of their authentication tokens
+// are revoked, signing out every session.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password       string `json:"password"`
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePasswordPlaintext(v, input.Password)
+	data.ValidateTokenPlaintext(v, input.TokenPlaintext)
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopePasswordReset, input.TokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(input.Password)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeAuthentication, user.ID)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the updateCurrentUserEmailHandler() handles "PUT /v1/users/me/email". The new address
+// is only stored as the user's pending email, and a token to confirm it is sent there,
+// along with a notice to the current address. The account keeps its current address
+// until the change is confirmed. As with changing the password, the current password
+// must be given.
+func (app *application) updateCurrentUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	v.Check(!strings.EqualFold(input.Email, user.Email), "email", "must be different from your current email address")
+	v.Check(input.Password != "", "password", "must be provided")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	if !match {
+		v.AddError("password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, err = app.models.Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	user.PendingEmail = input.Email
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	// only the token for the latest pending email is valid.
+	err = app.models.Tokens.DeleteAllForUser(data.ScopeEmailChange, user.ID)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	token, err := app.models.Tokens.New(user.ID, 24*time.Hour, data.ScopeEmailChange)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	app.background(func() {
+		err := app.mailer.Send(user.PendingEmail, "token_email_change.tmpl", map[string]any{
+			"emailChangeToken": token.Plaintext,
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+
+		err = app.mailer.Send(user.Email, "email_change_notice.tmpl", map[string]any{
+			"newEmail": user.PendingEmail,
+		})
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	})
+
+	env := envelop{"message": "a confirmation email has been sent to your new email address"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the confirmEmailChangeHandler() handles "PUT /v1/users/email", which swaps a user's
+// pending email in as their email address using the token from the confirmation email.
+// The address may have been taken by another account since the change was requested,
+// in which case the user's address is left as it was. Once the address has changed,
+// any password reset tokens which were sent to the old address are revoked.
+func (app *application) confirmEmailChangeHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		TokenPlaintext string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateTokenPlaintext(v, input.TokenPlaintext); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.models.Users.GetForToken(data.ScopeEmailChange, input.TokenPlaintext)
+	if err == nil && user.PendingEmail == "" {
+		err = data.ErrRecordNotFound
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired email change token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	user.Email = user.PendingEmail
+	user.PendingEmail = ""
+
+	err = app.models.Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	for _, scope := range []string{data.ScopeEmailChange, data.ScopePasswordReset} {
+		err = app.models.Tokens.DeleteAllForUser(scope, user.ID)
+		if err != nil {
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"user": user}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the listCurrentUserSessionsHandler() handles "GET /v1/users/me/sessions", listing the
+// user's unexpired authentication tokens along with where they were last used from.
+func (app *application) listCurrentUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	sessions, err := app.models.Tokens.GetSessionsForUser(user.ID, app.contextGetToken(r))
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"greenlight.mayuraandrew.tech/internal/data"
+	"net/http"
+	"time"
+)
+
+func (app *application) showWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	entry, err := app.models.Watchlist.Get(app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"watchlist_entry": entry}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+// the setWatchlistEntryHandler() adds a movie to the user's watchlist. The request body
+// is optional, and can contain a "watched" value to mark the movie as watched (or not).
+// If it is omitted, the existing watched at timestamp is left unchanged.
+func (app *application) setWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Watched *bool `json:"watched"`
+	}
+
+	if r.ContentLength != 0 {
+		err = app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	_, err = app.models.Movies.Get(movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	entry, err := app.models.Watchlist.Get(user.ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			entry = &data.WatchlistEntry{UserID: user.ID, MovieID: movieID}
+		default:
+			app.serverErrorRespone(w, r, err)
+			return
+		}
+	}
+
+	if input.Watched != nil {
+		switch {
+		case !*input.Watched:
+			entry.WatchedAt = nil
+		case entry.WatchedAt == nil:
+			now := time.Now()
+			entry.WatchedAt = &now
+		}
+	}
+
+	err = app.models.Watchlist.Set(entry)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"watchlist_entry": entry}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
+
+func (app *application) deleteWatchlistEntryHandler(w http.ResponseWriter, r *http.Request) {
+	movieID, err := app.readNamedIDParam(r, "movie_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Watchlist.Delete(app.contextGetUser(r).ID, movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorRespone(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelop{"message": "movie successfully removed from watchlist"}, nil)
+	if err != nil {
+		app.serverErrorRespone(w, r, err)
+	}
+}
@@ -0,0 +1,307 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"time"
+)
+
+var (
+	ErrUnknownMovie = errors.New("unknown movie")
+)
+
+// A Collection is a curated, ordered list of movies, such as a franchise or a list of
+// staff picks. It belongs to the user who created it, and is only visible to other
+// users if it is public. Movies holds the IDs of the movies in order.
+type Collection struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UserID      int64     `json:"user_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Public      bool      `json:"public"`
+	Movies      []int64   `json:"movies"`
+	Version     int32     `json:"version"`
+}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(collection.Description) <= 2000, "description", "must not be more than 2000 bytes long")
+
+	v.Check(collection.Movies != nil, "movies", "must be provided")
+	v.Check(len(collection.Movies) <= 500, "movies", "must not contain more than 500 movies")
+
+	seen := make(map[int64]bool, len(collection.Movies))
+	for _, id := range collection.Movies {
+		v.Check(id > 0, "movies", "must only contain positive integers")
+		v.Check(!seen[id], "movies", "must not contain duplicate values")
+		seen[id] = true
+	}
+}
+
+// VisibleTo() reports whether the user can see the collection: either it is public,
+// or it is their own.
+func (c *Collection) VisibleTo(userID int64) bool {
+	return c.Public || c.UserID == userID
+}
+
+// Define a CollectionModel struct type which wraps a sql.DB connection pool.
+type CollectionModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a new collection along with its movies. If one of the movies doesn't
+// exist or is in the trash it returns ErrUnknownMovie.
+func (m CollectionModel) Insert(collection *Collection) error {
+	query := `INSERT INTO collections (user_id, name, description, public)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, version`
+
+	args := []any{collection.UserID, collection.Name, collection.Description, collection.Public}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+	if err != nil {
+		return err
+	}
+
+	err = m.insertMovies(ctx, tx, collection, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertMovies() adds the collection's movies, numbering their positions from 1 in the
+// order they are listed. Movies in the trash are skipped by the join, unless they are in
+// previous (the movies the collection held before an update), so that a collection can
+// be edited without losing a movie that will come back when it is restored. If fewer
+// rows are inserted than there are movies, one of them is unknown or in the trash and
+// we return ErrUnknownMovie.
+func (m CollectionModel) insertMovies(ctx context.Context, tx *sql.Tx, collection *Collection, previous []int64) error {
+	query := `INSERT INTO collection_movies (collection_id, movie_id, position)
+		SELECT $1, movie.id, movie.position
+		FROM unnest($2::bigint[]) WITH ORDINALITY AS movie (id, position)
+		INNER JOIN movies ON movies.id = movie.id AND (movies.deleted_at IS NULL OR movies.id = ANY($3))`
+
+	args := []any{collection.ID, pq.Array(collection.Movies), pq.Array(previous)}
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		switch {
+		case isConstraintViolation(err, "23503", "collection_movies_movie_id_fkey"):
+			return ErrUnknownMovie
+		default:
+			return err
+		}
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(collection.Movies)) {
+		return ErrUnknownMovie
+	}
+	return nil
+}
+
+// the columns of a collection, with the IDs of its movies in order.
+const collectionColumns = `collections.id, collections.created_at, collections.user_id, collections.name,
+	collections.description, collections.public, collections.version,
+	ARRAY(
+		SELECT collection_movies.movie_id FROM collection_movies
+		WHERE collection_movies.collection_id = collections.id
+		ORDER BY collection_movies.position
+	)`
+
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + collectionColumns + `
+		FROM collections
+		WHERE id = $1`
+
+	var collection Collection
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.UserID,
+		&collection.Name,
+		&collection.Description,
+		&collection.Public,
+		&collection.Version,
+		pq.Array(&collection.Movies),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &collection, nil
+}
+
+// GetAll() returns a page of the collections which are visible to the user: the public
+// ones and their own. If ownerID is non-zero, only that user's collections are listed.
+func (m CollectionModel) GetAll(userID, ownerID int64, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), %s
+		FROM collections
+		WHERE (collections.public OR collections.user_id = $1)
+		AND ($2 = 0 OR collections.user_id = $2)
+		ORDER BY %s, id ASC
+		LIMIT $3 OFFSET $4`, collectionColumns, filters.orderBy())
+
+	args := []any{userID, ownerID, filters.limit(), filters.offset()}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		var collection Collection
+
+		err := rows.Scan(
+			&totalRecords,
+			&collection.ID,
+			&collection.CreatedAt,
+			&collection.UserID,
+			&collection.Name,
+			&collection.Description,
+			&collection.Public,
+			&collection.Version,
+			pq.Array(&collection.Movies),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		collections = append(collections, &collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return collections, metadata, nil
+}
+
+// Update() saves the collection and replaces its movies with those in the collection's
+// Movies, in their new order.
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `UPDATE collections
+		SET name = $1, description = $2, public = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []any{collection.Name, collection.Description, collection.Public, collection.ID, collection.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, `DELETE FROM collection_movies WHERE collection_id = $1 RETURNING movie_id`, collection.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var previous []int64
+
+	for rows.Next() {
+		var movieID int64
+
+		err := rows.Scan(&movieID)
+		if err != nil {
+			return err
+		}
+
+		previous = append(previous, movieID)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	err = m.insertMovies(ctx, tx, collection, previous)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM collections WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
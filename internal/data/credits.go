@@ -0,0 +1,176 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"time"
+)
+
+// Define constants for the roles that a person can have in a movie's credits.
+const (
+	RoleDirector = "director"
+	RoleWriter   = "writer"
+	RoleActor    = "actor"
+)
+
+var ErrPersonNotFound = errors.New("person not found")
+
+// Define a Credit struct which links a person to a movie. The Character and
+// BillingOrder fields only apply to actors. PersonName is read from the people table
+// so that credits can be embedded in a movie response without further lookups.
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"-"`
+	PersonID     int64  `json:"person_id"`
+	PersonName   string `json:"name"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order,omitempty"`
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, RoleDirector, RoleWriter, RoleActor), "role", "must be one of director, writer or actor")
+
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must not be negative")
+
+	// a character name and billing order only make sense for actors.
+	if credit.Role != RoleActor {
+		v.Check(credit.Character == "", "character", "must only be provided for actors")
+		v.Check(credit.BillingOrder == 0, "billing_order", "must only be provided for actors")
+	}
+}
+
+// Define a CreditModel struct type which wraps a sql.DB connection pool.
+type CreditModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a credit for a movie. If the referenced person doesn't exist, the
+// foreign key constraint is violated and we return ErrPersonNotFound. If the movie is
+// in the trash, no credit is added and we return ErrRecordNotFound.
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `WITH credit AS (
+			INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+			SELECT id, $2::bigint, $3::text, $4::text, $5::integer FROM movies WHERE id = $1 AND deleted_at IS NULL
+			RETURNING id, person_id
+		)
+		SELECT credit.id, people.name FROM credit INNER JOIN people ON people.id = credit.person_id`
+
+	args := []any{credit.MovieID, credit.PersonID, credit.Role, credit.Character, credit.BillingOrder}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID, &credit.PersonName)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: insert or update on table "movie_credits" violates foreign key constraint "movie_credits_person_id_fkey"`:
+			return ErrPersonNotFound
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAllForMovie() returns the credits for a movie, with directors and writers first
+// followed by the cast in billing order.
+func (m CreditModel) GetAllForMovie(movieID int64) ([]*Credit, error) {
+	credits, err := m.GetAllForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+
+	if credits[movieID] == nil {
+		return []*Credit{}, nil
+	}
+	return credits[movieID], nil
+}
+
+// GetAllForMovies() returns the credits for several movies at once, keyed by movie ID,
+// in the same order as GetAllForMovie(). Movies without any credits are left out of
+// the map.
+func (m CreditModel) GetAllForMovies(movieIDs []int64) (map[int64][]*Credit, error) {
+	query := `SELECT movie_credits.id, movie_credits.movie_id, movie_credits.person_id, people.name,
+		movie_credits.role, movie_credits.character, movie_credits.billing_order
+		FROM movie_credits
+		INNER JOIN people ON people.id = movie_credits.person_id
+		INNER JOIN movies ON movies.id = movie_credits.movie_id AND movies.deleted_at IS NULL
+		WHERE movie_credits.movie_id = ANY($1)
+		ORDER BY movie_credits.movie_id,
+		array_position(ARRAY['director', 'writer', 'actor'], movie_credits.role),
+		movie_credits.billing_order, movie_credits.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := make(map[int64][]*Credit)
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits[credit.MovieID] = append(credits[credit.MovieID], &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// Delete() removes a credit from a movie. The movie ID is checked so that a credit
+// can't be deleted through the URL of a different movie.
+func (m CreditModel) Delete(movieID, id int64) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM movie_credits WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
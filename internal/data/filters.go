@@ -1,24 +1,108 @@
 package data
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"greenlight.mayuraandrew.tech/internal/validator"
 	"math"
 	"strings"
 )
 
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Sort can hold several comma-separated sort keys, such as "-year,title", each of
+// which must be in SortSafelist. If Cursor is set, the listing uses keyset pagination instead of page/page_size:
+// it returns the records which come after the cursor position in the sort order.
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	Cursor       *Cursor
+}
+
+// Projection holds the fields= and include= query string parameters for a response.
+// Fields lists the fields of each record to return (all of them if it's empty), and
+// Include lists the related data to embed in each record. Like the sort parameter in
+// Filters, both are checked against safelists.
+type Projection struct {
+	Fields          []string
+	FieldsSafelist  []string
+	Include         []string
+	IncludeSafelist []string
 }
 
+// The Next field holds the position of the last record in the result when there are
+// more records to fetch. It isn't written to the response directly; the handler signs
+// it with EncodeCursor() and sets NextCursor.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int     `json:"current_page,omitempty"`
+	PageSize     int     `json:"page_size,omitempty"`
+	FirstPage    int     `json:"first_page,omitempty"`
+	LastPage     int     `json:"last_page,omitempty"`
+	TotalRecords int     `json:"total_records,omitempty"`
+	NextCursor   string  `json:"next_cursor,omitempty"`
+	Next         *Cursor `json:"-"`
+}
+
+// A Cursor records a position in a sorted listing: the sort parameter it was created
+// for, the values of the sort columns for the last record (one for each sort key), and
+// that record's ID as a tie-breaker. The values are held as strings and cast back by
+// PostgreSQL.
+type Cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	ID     int64    `json:"i"`
+}
+
+// EncodeCursor() returns the opaque form of the cursor which is sent to clients. This
+// is the base64-encoded JSON followed by a HMAC-SHA256 signature, so that clients
+// can't tamper with the values.
+func EncodeCursor(c Cursor, secret []byte) string {
+	js, _ := json.Marshal(c)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(js)
+
+	return base64.RawURLEncoding.EncodeToString(js) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// DecodeCursor() checks the signature on an opaque cursor and returns its contents,
+// or ErrInvalidCursor if it has been tampered with or isn't a cursor at all.
+func DecodeCursor(s string, secret []byte) (*Cursor, error) {
+	payload, signature, found := strings.Cut(s, ".")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+
+	js, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(js)
+
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+
+	err = json.Unmarshal(js, &c)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
 }
 
 // The calculateMetadata() function calculates the appropriate pagination
@@ -49,38 +133,104 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
 
-	// check that the sort parameter matches a value in the safelist.
+	// check that each of the sort keys matches a value in the safelist, and that no
+	// column is sorted on twice (in either direction).
 
-	v.Check(validator.In(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	keys := strings.Split(f.Sort, ",")
+	columns := make([]string, len(keys))
+	for i, key := range keys {
+		v.Check(validator.In(key, f.SortSafelist...), "sort", "invalid sort value")
+		columns[i] = sortColumn(key)
+	}
+	v.Check(validator.Unique(columns), "sort", "must not contain duplicate values")
+
+	// a cursor is only valid for the sort order it was created with.
+	if f.Cursor != nil {
+		v.Check(f.Cursor.Sort == f.Sort && len(f.Cursor.Values) == len(keys), "cursor", "does not match the sort value")
+	}
 }
 
-// check that the client-provided Sort field matches one of the entries in our safelist
-// and if it does, extract the column name from the Sort field by stripping the leading
-// hyphen character (if one exists).
+func ValidateProjection(v *validator.Validator, p Projection) {
+	for _, field := range p.Fields {
+		v.Check(validator.In(field, p.FieldsSafelist...), "fields", "invalid fields value")
+	}
+	v.Check(validator.Unique(p.Fields), "fields", "must not contain duplicate values")
 
-func (f Filters) sortColumn() string {
-	for _, safeValue := range f.SortSafelist {
-		if f.Sort == safeValue {
-			return strings.TrimPrefix(f.Sort, "-")
+	for _, include := range p.Include {
+		v.Check(validator.In(include, p.IncludeSafelist...), "include", "invalid include value")
+	}
+	v.Check(validator.Unique(p.Include), "include", "must not contain duplicate values")
+}
+
+// Includes() reports whether the client asked for the related data to be embedded.
+func (p Projection) Includes(name string) bool {
+	return validator.In(name, p.Include...)
+}
+
+// sortKeys() splits the Sort field into its sort keys, checking that each one matches
+// an entry in our safelist. The keys are later interpolated into SQL queries, so this
+// panics if the handler forgot to validate the filters first.
+
+func (f Filters) sortKeys() []string {
+	keys := strings.Split(f.Sort, ",")
+	for _, key := range keys {
+		if !validator.In(key, f.SortSafelist...) {
+			panic("unsafe sort parameter: " + f.Sort)
 		}
 	}
-	panic("unsafe sort parameter: " + f.Sort)
+	return keys
+}
+
+// orderBy() returns the ORDER BY list for the Sort field, such as "year DESC, title ASC"
+// for sort=-year,title. Callers add their own tie-breaker after it.
+func (f Filters) orderBy() string {
+	keys := f.sortKeys()
+
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		terms[i] = sortColumn(key) + " " + sortDirection(key)
+	}
+	return strings.Join(terms, ", ")
+}
+
+// extract the column name from a sort key by stripping the leading hyphen character
+// (if one exists).
+
+func sortColumn(key string) string {
+	return strings.TrimPrefix(key, "-")
 }
 
 // return the sort direction ("ASC" or "DESC") depending on the prefix character of the
-// sort field.
+// sort key.
 
-func (f Filters) sortDirection() string {
-	if strings.HasPrefix(f.Sort, "-") {
+func sortDirection(key string) string {
+	if strings.HasPrefix(key, "-") {
 		return "DESC"
 	}
 	return "ASC"
 }
 
+// in cursor mode we fetch one more record than the page size, so that we know whether
+// there is a next page without having to count the records.
 func (f Filters) limit() int {
+	if f.Cursor != nil {
+		return f.PageSize + 1
+	}
 	return f.PageSize
 }
 
 func (f Filters) offset() int {
+	if f.Cursor != nil {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
+
+// keysetOperator returns the comparison operator which selects the records after the
+// cursor for the direction of a sort key.
+func keysetOperator(key string) string {
+	if sortDirection(key) == "DESC" {
+		return "<"
+	}
+	return ">"
+}
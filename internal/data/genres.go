@@ -0,0 +1,404 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"strings"
+	"time"
+)
+
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+// A Genre is an entry in the catalogue of genres which movies can be given. Movies
+// store the genre's name, and the aliases are other names (such as "sci-fi" for
+// "science fiction") which are accepted for it and replaced by the name.
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+	v.Check(strings.TrimSpace(genre.Name) == genre.Name, "name", "must not start or end with spaces")
+	// the genres filter on GET /v1/movies is a comma-separated list.
+	v.Check(!strings.Contains(genre.Name, ","), "name", "must not contain commas")
+
+	v.Check(genre.Aliases != nil, "aliases", "must be provided")
+	v.Check(len(genre.Aliases) <= 20, "aliases", "must not contain more than 20 aliases")
+
+	// names are matched without regard to case, so they must be unique in the same way.
+	names := []string{strings.ToLower(genre.Name)}
+	for _, alias := range genre.Aliases {
+		v.Check(alias != "", "aliases", "must not contain empty values")
+		v.Check(len(alias) <= 100, "aliases", "must not contain values more than 100 bytes long")
+		v.Check(strings.TrimSpace(alias) == alias, "aliases", "must not contain values which start or end with spaces")
+		v.Check(!strings.Contains(alias, ","), "aliases", "must not contain commas")
+		names = append(names, strings.ToLower(alias))
+	}
+	v.Check(validator.Unique(names), "aliases", "must not contain duplicate values or the genre name")
+}
+
+// A GenreCatalogue maps every genre name and alias, in lower case, to the name of the
+// genre. It is loaded once per request by GenreModel.Catalogue(), for checking the
+// genres of movies with ValidateMovie().
+type GenreCatalogue map[string]string
+
+// Canonical() returns the name of the genre that a genre name or alias stands for,
+// ignoring case and surrounding spaces, and false if there is no such genre.
+func (c GenreCatalogue) Canonical(name string) (string, bool) {
+	genre, ok := c[strings.ToLower(strings.TrimSpace(name))]
+	return genre, ok
+}
+
+// Define a GenreModel struct type which wraps a sql.DB connection pool.
+type GenreModel struct {
+	DB *sql.DB
+}
+
+// the genre_names rows for a genre are always rewritten together, from its name and
+// aliases.
+const genreNamesInsert = `INSERT INTO genre_names (name, genre_id)
+	SELECT unnest($1::text[]), $2`
+
+// Insert() adds a new genre. If its name or one of its aliases is already used by
+// another genre it returns ErrDuplicateGenre.
+func (m GenreModel) Insert(genre *Genre) error {
+	query := `INSERT INTO genres (name)
+		VALUES ($1)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, genre.Name).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		return err
+	}
+
+	err = m.insertNames(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertNames() adds the genre_names rows for the genre's name and aliases.
+func (m GenreModel) insertNames(ctx context.Context, tx *sql.Tx, genre *Genre) error {
+	names := append([]string{genre.Name}, genre.Aliases...)
+
+	_, err := tx.ExecContext(ctx, genreNamesInsert, pq.Array(names), genre.ID)
+	if err != nil {
+		switch {
+		case isConstraintViolation(err, "23505", "genre_names_name_key"):
+			return ErrDuplicateGenre
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// the columns of a genre, with its aliases gathered from genre_names.
+const genreColumns = `genres.id, genres.created_at, genres.name, genres.version,
+	ARRAY(
+		SELECT genre_names.name FROM genre_names
+		WHERE genre_names.genre_id = genres.id AND genre_names.name <> genres.name
+		ORDER BY lower(genre_names.name)
+	)`
+
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT ` + genreColumns + `
+		FROM genres
+		WHERE id = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Name,
+		&genre.Version,
+		pq.Array(&genre.Aliases),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// GetAll() returns the whole catalogue in name order. It is small enough that it isn't
+// paginated.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `SELECT ` + genreColumns + `
+		FROM genres
+		ORDER BY lower(genres.name), genres.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&genre.ID,
+			&genre.CreatedAt,
+			&genre.Name,
+			&genre.Version,
+			pq.Array(&genre.Aliases),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// Catalogue() returns every genre name and alias, for checking the genres of movies.
+func (m GenreModel) Catalogue() (GenreCatalogue, error) {
+	query := `SELECT genre_names.name, genres.name
+		FROM genre_names
+		INNER JOIN genres ON genres.id = genre_names.genre_id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	catalogue := GenreCatalogue{}
+
+	for rows.Next() {
+		var name, genre string
+
+		err := rows.Scan(&name, &genre)
+		if err != nil {
+			return nil, err
+		}
+
+		catalogue[strings.ToLower(name)] = genre
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return catalogue, nil
+}
+
+// Update() saves the genre's name and aliases. If the genre has been renamed, every
+// movie with the genre (including those in the trash) is changed to the new name,
+// which records a revision of each of those movies for the user.
+func (m GenreModel) Update(genre *Genre, oldName string, userID int64) error {
+	query := `UPDATE genres
+		SET name = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	args := []any{genre.Name, genre.ID, genre.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM genre_names WHERE genre_id = $1`, genre.ID)
+	if err != nil {
+		return err
+	}
+
+	err = m.insertNames(ctx, tx, genre)
+	if err != nil {
+		return err
+	}
+
+	if genre.Name != oldName {
+		err = replaceMovieGenre(ctx, tx, oldName, genre.Name, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Merge() folds the source genre into the target genre: the source's name and aliases
+// become aliases of the target, the movies with the source genre are given the target
+// genre instead (recording a revision of each movie for the user), and the source
+// genre is deleted. The target's version number is incremented.
+func (m GenreModel) Merge(source, target *Genre, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE genre_names SET genre_id = $1 WHERE genre_id = $2`, target.ID, source.ID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1 AND version = $2`, source.ID, source.Version)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	query := `UPDATE genres
+		SET version = version + 1
+		WHERE id = $1 AND version = $2
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, target.ID, target.Version).Scan(&target.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	err = replaceMovieGenre(ctx, tx, source.Name, target.Name, userID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	target.Aliases = append(target.Aliases, source.Name)
+	target.Aliases = append(target.Aliases, source.Aliases...)
+	return nil
+}
+
+// Delete() removes a genre. A genre which is still used by any movie, including those
+// in the trash, can't be deleted and ErrGenreInUse is returned; it can be merged into
+// another genre instead.
+func (m GenreModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `WITH genre AS (
+		SELECT id, name FROM genres WHERE id = $1
+	), deleted AS (
+		DELETE FROM genres
+		WHERE id IN (SELECT id FROM genre)
+		AND NOT EXISTS (SELECT 1 FROM movies, genre WHERE movies.genres @> ARRAY[genre.name])
+		RETURNING id
+	)
+	SELECT EXISTS (SELECT 1 FROM genre), EXISTS (SELECT 1 FROM deleted)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var found, deleted bool
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&found, &deleted)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !found:
+		return ErrRecordNotFound
+	case !deleted:
+		return ErrGenreInUse
+	}
+	return nil
+}
+
+// replaceMovieGenre() changes the genre from to the genre to on every movie that has
+// it, dropping it instead if the movie already has both. Either way the movie is left
+// with the genre to, so it can't end up without any genres. Each changed movie has its
+// version number incremented and a revision recorded.
+func replaceMovieGenre(ctx context.Context, tx *sql.Tx, from, to string, userID int64) error {
+	query := `WITH movie AS (
+		UPDATE movies
+		SET genres = CASE WHEN genres @> ARRAY[$4::text] THEN array_remove(genres, $3::text)
+			ELSE array_replace(genres, $3::text, $4::text) END,
+			version = version + 1
+		WHERE genres @> ARRAY[$3::text]
+		RETURNING id, version, title, year, runtime, genres
+	), ` + movieRevisionInsert + `
+	SELECT count(*) FROM movie`
+
+	var count int
+
+	return tx.QueryRowContext(ctx, query, RevisionUpdate, userID, from, to).Scan(&count)
+}
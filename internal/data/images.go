@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"time"
+)
+
+// ImageKinds lists the kinds of artwork which can be uploaded for a movie. Each one is
+// stored in the movies column of the same name.
+var ImageKinds = []string{"poster", "backdrop"}
+
+// An Image describes a piece of artwork uploaded for a movie: the URL and size of the
+// original image, and the URLs of its thumbnails by size name. Keys holds the storage
+// keys of all of the files, so that they can be deleted when the image is replaced;
+// it is saved in the database but not shown to clients.
+type Image struct {
+	URL        string            `json:"url"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+	Keys       []string          `json:"-"`
+}
+
+// imageColumn is the JSON format an Image is stored in, which includes the keys.
+type imageColumn struct {
+	URL        string            `json:"url"`
+	Width      int               `json:"width"`
+	Height     int               `json:"height"`
+	Thumbnails map[string]string `json:"thumbnails,omitempty"`
+	Keys       []string          `json:"keys"`
+}
+
+// Value() implements the driver.Valuer interface, so that an Image can be written to
+// a jsonb column.
+func (i Image) Value() (driver.Value, error) {
+	return json.Marshal(imageColumn(i))
+}
+
+// Scan() implements the sql.Scanner interface, so that an Image can be read from a
+// jsonb column.
+func (i *Image) Scan(src any) error {
+	var js []byte
+
+	switch src := src.(type) {
+	case []byte:
+		js = src
+	case string:
+		js = []byte(src)
+	default:
+		return fmt.Errorf("cannot scan %T into an Image", src)
+	}
+
+	var column imageColumn
+
+	err := json.Unmarshal(js, &column)
+	if err != nil {
+		return err
+	}
+
+	*i = Image(column)
+	return nil
+}
+
+// SetImage() saves a new image of the given kind for the movie, or removes it if image
+// is nil, and returns the image it replaced (if any) so that its files can be deleted.
+// Changing the artwork counts as a change to the movie, so this checks against the
+// version number to prevent edit conflicts, increments it, and records a revision.
+func (m MovieModel) SetImage(movie *Movie, kind string, image *Image, userID int64) (*Image, error) {
+	if !validator.In(kind, ImageKinds...) {
+		panic("unknown movie image kind: " + kind)
+	}
+
+	// the "old" CTE sees the row as it was before the update, because all of the
+	// parts of the statement use the same snapshot of the database.
+	query := `WITH old AS (
+		SELECT ` + kind + ` AS image FROM movies WHERE id = $3
+	), movie AS (
+		UPDATE movies SET ` + kind + ` = $4, version = version + 1
+		WHERE id = $3 AND version = $5 AND deleted_at IS NULL
+		RETURNING id, version, title, year, runtime, genres
+	), ` + movieRevisionInsert + `
+	SELECT movie.version, old.image FROM movie, old`
+
+	// a nil *Image has to be passed as an untyped nil, otherwise the driver would call
+	// Value() on the nil pointer.
+	var value any
+	if image != nil {
+		value = *image
+	}
+
+	args := []any{RevisionUpdate, userID, movie.ID, value, movie.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var old *Image
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &old)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEditConflict
+		default:
+			return nil, err
+		}
+	}
+
+	switch kind {
+	case "poster":
+		movie.Poster = image
+	case "backdrop":
+		movie.Backdrop = image
+	}
+
+	return old, nil
+}
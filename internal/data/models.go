@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"github.com/lib/pq"
 )
 
 // define a custom errors
@@ -11,6 +12,14 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// isConstraintViolation() reports whether err is a PostgreSQL error with the given
+// error code (such as "23505" for unique_violation, or "23503" for
+// foreign_key_violation) raised by the named constraint.
+func isConstraintViolation(err error, code pq.ErrorCode, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == code && pqErr.Constraint == constraint
+}
+
 //type Models struct {
 //// Set the Movies field to be an interface containing the methods that both the
 //// 'real' model and mock model need to support.
@@ -36,6 +45,14 @@ type Models struct {
 	Users       UserModel
 	Tokens      TokenModel
 	Permissions PermissionModel
+	Reviews     ReviewModel
+	Watchlist   WatchlistModel
+	People      PersonModel
+	Credits     CreditModel
+	Revisions   MovieRevisionModel
+	Genres      GenreModel
+	Collections CollectionModel
+	Releases    ReleaseModel
 }
 
 // for each of use, we also add a new() method which return a Models struct containing
@@ -47,5 +64,13 @@ func NewModels(db *sql.DB) Models {
 		Permissions: PermissionModel{DB: db},
 		Users:       UserModel{DB: db},
 		Tokens:      TokenModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
+		Watchlist:   WatchlistModel{DB: db},
+		People:      PersonModel{DB: db},
+		Credits:     CreditModel{DB: db},
+		Revisions:   MovieRevisionModel{DB: db},
+		Genres:      GenreModel{DB: db},
+		Collections: CollectionModel{DB: db},
+		Releases:    ReleaseModel{DB: db},
 	}
 }
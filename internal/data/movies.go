@@ -7,20 +7,55 @@ import (
 	"fmt"
 	"github.com/lib/pq"
 	"greenlight.mayuraandrew.tech/internal/validator"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
+)
+
+var (
+	ErrDuplicateMovieID = errors.New("duplicate movie id")
+	ErrMovieInTrash     = errors.New("movie in trash")
 )
 
 type Movie struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
-	Title     string    `json:"title"`
-	Year      int32     `json:"year,omitempty"`
-	Runtime   Runtime   `json:"runtime,omitempty"`
-	Genres    []string  `json:"genres"`
-	Version   int32     `json:"version"`
+	ID            int64      `json:"id"`
+	CreatedAt     time.Time  `json:"-"`
+	Title         string     `json:"title"`
+	Year          int32      `json:"year,omitempty"`
+	Runtime       Runtime    `json:"runtime,omitempty"`
+	Genres        []string   `json:"genres"`
+	Version       int32      `json:"version"`
+	AverageRating float64    `json:"average_rating"`
+	RatingCount   int64      `json:"rating_count"`
+	Poster        *Image     `json:"poster,omitempty"`
+	Backdrop      *Image     `json:"backdrop,omitempty"`
+	Credits       []*Credit  `json:"credits,omitempty"`
+	Releases      []*Release `json:"releases,omitempty"`
+	CreatedBy     *Creator   `json:"created_by,omitempty"`
+
+	// DeletedAt is only set for movies in the trash, as returned by GetTrash().
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+
+	// relevance holds the (negated) search rank of the movie when it was read by
+	// GetAll(), for use in pagination cursors, and position holds its position in the
+	// collection that the listing was filtered by.
+	relevance float64
+	position  int32
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+// the ratings for a movie are aggregated from the reviews table when the movie is read.
+// The average is exposed under the "rating" column name so that it can be used
+// directly as a sort column in GetAll().
+const movieRatingsJoin = `LEFT JOIN LATERAL (
+		SELECT round(avg(rating), 1)::float8 AS average_rating, count(*) AS rating_count
+		FROM reviews WHERE reviews.movie_id = movies.id
+	) AS ratings ON true`
+
+// ValidateMovie() checks the movie's fields. Its genres must be in the catalogue: each
+// one is matched against the genre names and aliases without regard to case, and is
+// replaced by the name of the genre, so that the same genre is always stored the same way.
+func ValidateMovie(v *validator.Validator, movie *Movie, genres GenreCatalogue) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
@@ -34,24 +69,80 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+
+	for i, genre := range movie.Genres {
+		name, ok := genres.Canonical(genre)
+		if !ok {
+			v.AddError("genres", fmt.Sprintf("must only contain known genres (%q is not one)", genre))
+			continue
+		}
+		movie.Genres[i] = name
+	}
+
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
 
+// SearchLanguages lists the PostgreSQL text search configurations which can be used
+// for stemming words in title searches.
+var SearchLanguages = []string{
+	"simple", "danish", "dutch", "english", "finnish", "french", "german", "hungarian",
+	"italian", "norwegian", "portuguese", "romanian", "russian", "spanish", "swedish", "turkish",
+}
+
+// The SearchLanguage field sets the text search configuration used for title
+// searches. It must be one of SearchLanguages, and defaults to "simple" (no stemming).
 type MovieModel struct {
-	DB *sql.DB
+	DB             *sql.DB
+	SearchLanguage string
+}
+
+func (m MovieModel) searchLanguage() string {
+	if validator.In(m.SearchLanguage, SearchLanguages...) {
+		return m.SearchLanguage
+	}
+	return "simple"
 }
 
-func (m MovieModel) Insert(movie *Movie) error {
-	query := `INSERT INTO movies 
-    (title, year, runtime, genres) 
-	VALUES ($1, $2, $3, $4) 
-	RETURNING id, created_at, version`
+// prefixQuery() converts a search string into a tsquery expression which matches
+// text containing all of the words, where each word can also be the prefix of a
+// longer word. Anything other than letters and digits is dropped, so the result is
+// always safe to pass to to_tsquery().
+func prefixQuery(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i := range words {
+		words[i] += ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// movieRevisionInsert is the final part of the data-modifying queries in Insert(),
+// Update() and Delete(). It records the state of the movie returned by the "movie"
+// CTE in the movie_revisions table, in the same statement as the change itself. The
+// action and the ID of the user making the change are passed as $1 and $2.
+const movieRevisionInsert = `revision AS (
+		INSERT INTO movie_revisions (movie_id, version, action, user_id, title, year, runtime, genres)
+		SELECT id, version, $1, NULLIF($2::bigint, 0), title, year, runtime, genres FROM movie
+	)`
+
+// Insert() adds a new movie, recording it as the first revision of the movie along
+// with the ID of the user who created it.
+func (m MovieModel) Insert(movie *Movie, userID int64) error {
+	query := `WITH movie AS (
+		INSERT INTO movies (title, year, runtime, genres)
+		VALUES ($3, $4, $5, $6)
+		RETURNING id, created_at, version, title, year, runtime, genres
+	), ` + movieRevisionInsert + `
+	SELECT id, created_at, version FROM movie`
 
 	// create an args slice containing the values for the placeholder parameters from
 	// the movie struct. Declaring this slice immedialety next to our SQL query helps to
 	// make it nice and clear *what values are beign used where* in the query.
 
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{RevisionInsert, userID, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -62,6 +153,132 @@ func (m MovieModel) Insert(movie *Movie) error {
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
 }
 
+// InsertWithID() adds a new movie using the ID already set on the movie, rather than
+// one generated by the database. It is used to create movies by PUT, so that an
+// upstream catalogue can be synced idempotently. If the ID belongs to a movie in the
+// trash, it returns ErrMovieInTrash, and if it is (or has ever been) used by another
+// movie, it returns ErrDuplicateMovieID.
+func (m MovieModel) InsertWithID(movie *Movie, userID int64) error {
+	if movie.ID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `WITH movie AS (
+		INSERT INTO movies (id, title, year, runtime, genres)
+		VALUES ($3, $4, $5, $6, $7)
+		RETURNING id, created_at, version, title, year, runtime, genres
+	), ` + movieRevisionInsert + `
+	SELECT id, created_at, version FROM movie`
+
+	args := []any{RevisionInsert, userID, movie.ID, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// the rollback is a no-op if the transaction has already been committed.
+	defer tx.Rollback()
+
+	var trashed bool
+
+	err = tx.QueryRowContext(ctx, `SELECT true FROM movies WHERE id = $1 AND deleted_at IS NOT NULL`, movie.ID).Scan(&trashed)
+	switch {
+	case err == nil:
+		return ErrMovieInTrash
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		switch {
+		case isConstraintViolation(err, "23505", "movies_pkey"):
+			return ErrDuplicateMovieID
+		case isConstraintViolation(err, "23505", "movie_revisions_movie_id_version_key"):
+			return ErrDuplicateMovieID
+		default:
+			return err
+		}
+	}
+
+	// move the ID sequence past the new ID, so that the database doesn't later
+	// generate the same ID for a movie created by POST. The sequence is left alone if
+	// it is already past it.
+	_, err = tx.ExecContext(ctx, `SELECT setval('movies_id_seq', $1)
+		FROM movies_id_seq WHERE last_value < $1`, movie.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertMany() inserts a batch of movies in a single transaction, using the
+// PostgreSQL COPY protocol so that large imports are fast. Either all of the movies
+// are inserted or none of them are. The rows are copied into a temporary table first,
+// so that a revision can be recorded for each new movie; note that the generated IDs
+// aren't returned.
+func (m MovieModel) InsertMany(movies []*Movie, userID int64) error {
+	// a large import can take longer than our usual 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `CREATE TEMPORARY TABLE movies_import (
+		title text, year integer, runtime integer, genres text[]
+	) ON COMMIT DROP`)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("movies_import", "title", "year", "runtime", "genres"))
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		_, err = stmt.ExecContext(ctx, movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres))
+		if err != nil {
+			stmt.Close()
+			return err
+		}
+	}
+
+	// calling Exec() with no arguments flushes the buffered rows to the database.
+	_, err = stmt.ExecContext(ctx)
+	if err != nil {
+		stmt.Close()
+		return err
+	}
+
+	err = stmt.Close()
+	if err != nil {
+		return err
+	}
+
+	query := `WITH movie AS (
+		INSERT INTO movies (title, year, runtime, genres)
+		SELECT title, year, runtime, genres FROM movies_import
+		RETURNING id, version, title, year, runtime, genres
+	), ` + movieRevisionInsert + `
+	SELECT count(*) FROM movie`
+
+	_, err = tx.ExecContext(ctx, query, RevisionInsert, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (m MovieModel) Get(id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
@@ -69,8 +286,10 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 
 	// define the SQL query for retrieving the movie data.
 
-	query := `SELECT id, created_at, title, year, runtime, genres, version 
-	FROM movies WHERE id = $1`
+	query := `SELECT id, created_at, title, year, runtime, genres, version, poster, backdrop,
+	COALESCE(ratings.average_rating, 0), ratings.rating_count
+	FROM movies ` + movieRatingsJoin + `
+	WHERE id = $1 AND deleted_at IS NULL`
 
 	// declare a Movie struct to hold the data returned by the query.
 	var movie Movie
@@ -91,7 +310,11 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
-		&movie.Version)
+		&movie.Version,
+		&movie.Poster,
+		&movie.Backdrop,
+		&movie.AverageRating,
+		&movie.RatingCount)
 
 	// handle any errors. if there was no matching movie found,
 	// Scan() will return a sql.ErrNoRows error.
@@ -108,13 +331,30 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	return &movie, nil
 }
 
-func (m MovieModel) Update(movie *Movie) error {
-	query := `UPDATE movies SET title = $1, year = $2, runtime = $3, genres =$4, version = version + 1 
-              WHERE id = $5 AND version = $6 
-              RETURNING version`
+// Update() saves the changes to a movie, checking against the version number to
+// prevent edit conflicts, and records the new state as a revision made by the user.
+func (m MovieModel) Update(movie *Movie, userID int64) error {
+	return m.update(movie, userID, RevisionUpdate)
+}
+
+// Rollback() works like Update(), but records the change as a restore, for when the
+// movie's fields have been copied from one of its earlier revisions.
+func (m MovieModel) Rollback(movie *Movie, userID int64) error {
+	return m.update(movie, userID, RevisionRestore)
+}
+
+func (m MovieModel) update(movie *Movie, userID int64, action string) error {
+	query := `WITH movie AS (
+		UPDATE movies SET title = $3, year = $4, runtime = $5, genres = $6, version = version + 1
+		WHERE id = $7 AND version = $8 AND deleted_at IS NULL
+		RETURNING id, version, title, year, runtime, genres
+	), ` + movieRevisionInsert + `
+	SELECT version FROM movie`
 
 	// create an args slice containing the values for the placeholder parameters.
 	args := []interface{}{
+		action,
+		userID,
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
@@ -144,65 +384,385 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// Delete() moves a movie to the trash. The movie is kept in the database, but can no
+// longer be read or updated until it is restored, and is eventually removed for good
+// by PurgeTrash(). Trashing a movie counts as a change, so its version is incremented
+// and the final state of the movie is recorded as a "delete" revision. If version isn't
+// zero, the movie is only deleted if it still has that version, and otherwise we return
+// an ErrEditConflict error.
+func (m MovieModel) Delete(id int64, userID int64, version int32) error {
+	return m.setDeleted(id, userID, version, true)
+}
+
+// Restore() takes a movie back out of the trash, recording a "restore" revision. If
+// the movie isn't in the trash, it returns ErrRecordNotFound.
+func (m MovieModel) Restore(id int64, userID int64) error {
+	return m.setDeleted(id, userID, 0, false)
+}
+
+func (m MovieModel) setDeleted(id int64, userID int64, version int32, deleted bool) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
-	query := `DELETE FROM movies WHERE id = $1`
+	action, set, where := RevisionDelete, "NOW()", "deleted_at IS NULL"
+	if !deleted {
+		action, set, where = RevisionRestore, "NULL", "deleted_at IS NOT NULL"
+	}
 
-	// execute the SQL query using Exec() method, passing in the id variables as the value for the placeholder parameter. The Exec() method
-	// returns a sql.Result object.
+	query := `WITH movie AS (
+		UPDATE movies SET deleted_at = ` + set + `, version = version + 1
+		WHERE id = $3 AND ($4 = 0 OR version = $4) AND ` + where + `
+		RETURNING id, version, title, year, runtime, genres
+	), ` + movieRevisionInsert + `
+	SELECT count(*) FROM movie`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	// the query returns the number of movies changed. If this is zero, we know that
+	// there wasn't a matching movie (in or out of the trash, as appropriate, and with
+	// the expected version if one was given) at the moment we tried to change it. In
+	// that case we return an ErrEditConflict error if a version was given, and an
+	// ErrRecordNotFound error otherwise.
+	var changed int
+
+	err := m.DB.QueryRowContext(ctx, query, action, userID, id, version).Scan(&changed)
 	if err != nil {
 		return err
 	}
 
-	// Call the RowsAffected() method on the sql.Result object to get the number of rows.
-	// affected by the query.
-	rowsAffected, err := result.RowsAffected()
+	if changed == 0 {
+		if version != 0 {
+			return ErrEditConflict
+		}
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetTrash() returns a page of the movies in the trash, along with the pagination
+// metadata. The sort column must be one of id, title or deleted_at.
+func (m MovieModel) GetTrash(filters Filters) ([]*Movie, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version, poster, backdrop,
+	COALESCE(ratings.average_rating, 0), ratings.rating_count, deleted_at
+	FROM movies `+movieRatingsJoin+`
+	WHERE deleted_at IS NOT NULL
+	ORDER BY %s, id ASC
+	LIMIT $1 OFFSET $2`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
 	if err != nil {
-		return err
+		return nil, Metadata{}, err
 	}
+	defer rows.Close()
 
-	// if no rows were affected, we know that the movies table didn't contain a record
-	// with the provided ID at the moment we tried to delete it. In that case we
-	// return an ErrRecordNotFound error.
+	totalRecords := 0
+	movies := []*Movie{}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&totalRecords,
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.Poster,
+			&movie.Backdrop,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.DeletedAt)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
 	}
-	return nil
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return movies, metadata, nil
+}
+
+// PurgeTrash() permanently deletes the movies which have been in the trash for longer
+// than the retention period, returning the number of movies deleted along with their
+// posters and backdrops, so that the caller can delete the image files. Their reviews,
+// credits and watchlist entries go with them, but their revisions are kept.
+func (m MovieModel) PurgeTrash(retention time.Duration) (int64, []*Image, error) {
+	query := `DELETE FROM movies
+		WHERE deleted_at IS NOT NULL AND deleted_at < NOW() - make_interval(secs => $1)
+		RETURNING poster, backdrop`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+
+	var purged int64
+	images := []*Image{}
+
+	for rows.Next() {
+		var poster, backdrop *Image
+
+		err := rows.Scan(&poster, &backdrop)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		purged++
+		for _, image := range []*Image{poster, backdrop} {
+			if image != nil {
+				images = append(images, image)
+			}
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	return purged, images, nil
+}
+
+// movieSortExpressions maps the sort columns used in GetAll() to the SQL expressions
+// they are computed from, for use in the keyset condition where column aliases can't
+// be referenced.
+var movieSortExpressions = map[string]string{
+	"id":        "movies.id",
+	"title":     "movies.title",
+	"year":      "movies.year",
+	"runtime":   "movies.runtime",
+	"rating":    "COALESCE(ratings.average_rating, 0)",
+	"relevance": "search.relevance",
+	"position":  "collection.position",
+}
+
+// cursor() returns the position of the movie in a listing sorted by the given sort
+// parameter, with the movie's value for each of the sort keys.
+func (movie *Movie) cursor(sort string) *Cursor {
+	keys := strings.Split(sort, ",")
+	values := make([]string, len(keys))
+
+	for i, key := range keys {
+		switch sortColumn(key) {
+		case "title":
+			values[i] = movie.Title
+		case "year":
+			values[i] = strconv.FormatInt(int64(movie.Year), 10)
+		case "runtime":
+			values[i] = strconv.FormatInt(int64(movie.Runtime), 10)
+		case "rating":
+			values[i] = strconv.FormatFloat(movie.AverageRating, 'f', -1, 64)
+		case "relevance":
+			values[i] = strconv.FormatFloat(movie.relevance, 'g', -1, 64)
+		case "position":
+			values[i] = strconv.FormatInt(int64(movie.position), 10)
+		default:
+			values[i] = strconv.FormatInt(movie.ID, 10)
+		}
+	}
+
+	return &Cursor{Sort: sort, Values: values, ID: movie.ID}
+}
+
+// MovieFilters holds the optional filters which can be applied when listing movies.
+// The zero value doesn't filter anything out. The Watchlist and Watched filters are
+// pointers so that "not provided" can be told apart from false, and are applied to
+// the watchlist of the user identified by UserID. DirectorID and ActorID are person
+// IDs, and CollectionID a collection ID, with zero meaning no filter. The year and
+// runtime ranges are inclusive, and again zero means no limit. Region is a country code
+// which limits the listing to movies already released in that country. GenresMode is "all" (the default) to match movies with
+// every one of the Genres, or "any" to match movies with at least one of them.
+type MovieFilters struct {
+	Title        string
+	Genres       []string
+	GenresMode   string
+	UserID       int64
+	Watchlist    *bool
+	Watched      *bool
+	DirectorID   int64
+	ActorID      int64
+	CollectionID int64
+	Region       string
+	YearMin      int32
+	YearMax      int32
+	RuntimeMin   Runtime
+	RuntimeMax   Runtime
+}
+
+// GenresModes lists the accepted values of MovieFilters.GenresMode.
+var GenresModes = []string{"all", "any"}
+
+// ValidateMovieFilters() checks the genres mode and region, and that the year and
+// runtime ranges are the right way round. Like ValidateMovie(), it replaces each of the
+// genres with its canonical name from the catalogue, as that is the name stored on the
+// movies, and records an error for genres which aren't in the catalogue.
+func ValidateMovieFilters(v *validator.Validator, f *MovieFilters, genres GenreCatalogue) {
+	for i, genre := range f.Genres {
+		name, ok := genres.Canonical(genre)
+		if !ok {
+			v.AddError("genres", fmt.Sprintf("must only contain known genres (%q is not one)", genre))
+			continue
+		}
+		f.Genres[i] = name
+	}
+
+	v.Check(f.GenresMode == "" || validator.In(f.GenresMode, GenresModes...), "genres_mode", "must be all or any")
+
+	v.Check(f.YearMin >= 0, "year_min", "must be a positive integer")
+	v.Check(f.YearMax >= 0, "year_max", "must be a positive integer")
+	v.Check(f.YearMin == 0 || f.YearMax == 0 || f.YearMin <= f.YearMax, "year_max", "must not be less than year_min")
+
+	v.Check(f.RuntimeMin >= 0, "runtime_min", "must be a positive integer")
+	v.Check(f.RuntimeMax >= 0, "runtime_max", "must be a positive integer")
+	v.Check(f.RuntimeMin == 0 || f.RuntimeMax == 0 || f.RuntimeMin <= f.RuntimeMax, "runtime_max", "must not be less than runtime_min")
+
+	v.Check(f.Region == "" || validator.Matches(f.Region, CountryRx), "region", "must be a two-letter ISO 3166-1 country code")
+}
+
+// filterClause() returns the FROM and WHERE clauses which select the movies matching
+// the filters, along with the values for their placeholder parameters. It is shared
+// by GetAll() and Facets(), so that facet counts are computed over exactly the same
+// movies as the listing. Callers can append their own placeholders after these.
+func (m MovieModel) filterClause(movieFilters MovieFilters) (string, []any) {
+	// the title is matched in three ways: a full-text search where every word can be a
+	// prefix ($8), using the configured search language for stemming, and a trigram
+	// word similarity match on the raw title ($1) to catch misspellings. Full-text
+	// matches are ranked above the similarity-only matches. The relevance is negated,
+	// so that sort=relevance (ascending) lists the best matches first.
+	searchJoin := fmt.Sprintf(`CROSS JOIN LATERAL (
+		SELECT -(CASE WHEN to_tsvector('%[1]s', movies.title) @@ to_tsquery('%[1]s', $8)
+			THEN 1 + ts_rank(to_tsvector('%[1]s', movies.title), to_tsquery('%[1]s', $8))
+			ELSE word_similarity($1, movies.title) END)::float8 AS relevance,
+		to_tsvector('%[1]s', movies.title) @@ to_tsquery('%[1]s', $8) AS matched
+	) AS search`, m.searchLanguage())
+
+	// the position of the movie in the collection given by $14, if any.
+	collectionJoin := `LEFT JOIN LATERAL (
+		SELECT position FROM collection_movies
+		WHERE collection_movies.collection_id = $14 AND collection_movies.movie_id = movies.id
+	) AS collection ON true`
+
+	clause := `FROM movies
+	` + movieRatingsJoin + `
+	` + searchJoin + `
+	` + collectionJoin + `
+	WHERE movies.deleted_at IS NULL
+	AND ($1 = '' OR search.matched OR $1 <% movies.title)
+	AND ($2 = '{}' OR ($13 = 'any' AND genres && $2) OR ($13 <> 'any' AND genres @> $2))
+	AND ($3::boolean IS NULL OR EXISTS (
+		SELECT 1 FROM watchlist WHERE watchlist.movie_id = movies.id AND watchlist.user_id = $5
+	) = $3)
+	AND ($4::boolean IS NULL OR EXISTS (
+		SELECT 1 FROM watchlist WHERE watchlist.movie_id = movies.id AND watchlist.user_id = $5
+		AND watchlist.watched_at IS NOT NULL
+	) = $4)
+	AND ($6 = 0 OR EXISTS (
+		SELECT 1 FROM movie_credits WHERE movie_credits.movie_id = movies.id
+		AND movie_credits.person_id = $6 AND movie_credits.role = 'director'
+	))
+	AND ($7 = 0 OR EXISTS (
+		SELECT 1 FROM movie_credits WHERE movie_credits.movie_id = movies.id
+		AND movie_credits.person_id = $7 AND movie_credits.role = 'actor'
+	))
+	AND ($9 = 0 OR movies.year >= $9)
+	AND ($10 = 0 OR movies.year <= $10)
+	AND ($11 = 0 OR movies.runtime >= $11)
+	AND ($12 = 0 OR movies.runtime <= $12)
+	AND ($14 = 0 OR collection.position IS NOT NULL)
+	AND ($15 = '' OR EXISTS (
+		SELECT 1 FROM movie_releases WHERE movie_releases.movie_id = movies.id
+		AND movie_releases.country = $15 AND movie_releases.release_date <= CURRENT_DATE
+	))`
+
+	args := []any{
+		movieFilters.Title,
+		pq.Array(movieFilters.Genres),
+		movieFilters.Watchlist,
+		movieFilters.Watched,
+		movieFilters.UserID,
+		movieFilters.DirectorID,
+		movieFilters.ActorID,
+		prefixQuery(movieFilters.Title),
+		movieFilters.YearMin,
+		movieFilters.YearMax,
+		movieFilters.RuntimeMin,
+		movieFilters.RuntimeMax,
+		movieFilters.GenresMode,
+		movieFilters.CollectionID,
+		movieFilters.Region,
+	}
+
+	return clause, args
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+func (m MovieModel) GetAll(movieFilters MovieFilters, filters Filters) ([]*Movie, Metadata, error) {
 	// construct the SQL query to retrieve all movie reords.
+	clause, args := m.filterClause(movieFilters)
 
-	// full-text search for the title filter
+	// in cursor mode we don't count the total number of records, and we add a keyset
+	// condition which selects the records that come after the cursor position in the
+	// sort order, using the movie ID as a tie-breaker. With several sort keys a record
+	// comes after the cursor if it is past it on the first key, or level on the first
+	// key and past it on the second, and so on, e.g. for sort=-year,title:
 	//
-	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-	FROM movies 
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-	AND (genres @> $2 OR $2 = '{}')
-	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+	//	(year < $a) OR (year = $a AND title > $b) OR (year = $a AND title = $b AND id > $c)
+	totalColumn := "count(*) OVER()"
+	keyset := "true"
+	if filters.Cursor != nil {
+		totalColumn = "0"
+
+		keys := filters.sortKeys()
+		conditions := make([]string, 0, len(keys)+1)
+		level := ""
+		for i, key := range keys {
+			expression := movieSortExpressions[sortColumn(key)]
+			param := len(args) + 3 + i
+			conditions = append(conditions, fmt.Sprintf("(%s%s %s $%d)", level, expression, keysetOperator(key), param))
+			level += fmt.Sprintf("%s = $%d AND ", expression, param)
+		}
+		conditions = append(conditions, fmt.Sprintf("(%smovies.id > $%d)", level, len(args)+3+len(keys)))
+
+		keyset = "(" + strings.Join(conditions, " OR ") + ")"
+	}
+
+	query := fmt.Sprintf(`SELECT %s, id, created_at, title, year, runtime, genres, version, poster, backdrop,
+	COALESCE(ratings.average_rating, 0) AS rating, ratings.rating_count, search.relevance,
+	COALESCE(collection.position, 0) AS position
+	%s
+	AND %s
+	ORDER BY %s, id ASC
+	LIMIT $%d OFFSET $%d`, totalColumn, clause, keyset, filters.orderBy(), len(args)+1, len(args)+2)
 
 	// create a context with a 3-second timeout.
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	/// as our SQL query now has quite a few placeholder parameters, let's collect the values
-	// values for the placeholders in a slice. Notice here how we call the limit() and offset() methods
-	// on the Filters struct to get the appropriate values for the
-	// LIMIT and OFFSET clauses.
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	// Notice here how we call the limit() and offset() methods on the Filters struct
+	// to get the appropriate values for the LIMIT and OFFSET clauses.
+	args = append(args, filters.limit(), filters.offset())
+	if filters.Cursor != nil {
+		for _, value := range filters.Cursor.Values {
+			args = append(args, value)
+		}
+		args = append(args, filters.Cursor.ID)
+	}
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, Metadata{}, err
@@ -230,7 +790,13 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
-			&movie.Version)
+			&movie.Version,
+			&movie.Poster,
+			&movie.Backdrop,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&movie.relevance,
+			&movie.position)
 
 		if err != nil {
 			return nil, Metadata{}, err
@@ -249,13 +815,205 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	}
 
 	// generate a metadata struct, passing in the total record count and pagination,
-	// parameters from the client.
-	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	// parameters from the client. If there are more records after this page, we also
+	// record the position of the last movie so that the client can continue from it.
+	var metadata Metadata
+	if filters.Cursor != nil {
+		metadata = Metadata{PageSize: filters.PageSize}
+		if len(movies) > filters.PageSize {
+			movies = movies[:filters.PageSize]
+			metadata.Next = movies[len(movies)-1].cursor(filters.Sort)
+		}
+	} else {
+		metadata = calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+		if metadata.CurrentPage < metadata.LastPage && len(movies) > 0 {
+			metadata.Next = movies[len(movies)-1].cursor(filters.Sort)
+		}
+	}
 	// if everything went ok, then return the slice of movies.
 	return movies, metadata, nil
 
 }
 
+// Export() calls fn for each movie matching the filters, in ID order. The rows are
+// read from the database one at a time as fn consumes them, so the whole result set
+// is never held in memory. If fn returns an error, the export stops and that error
+// is returned.
+func (m MovieModel) Export(movieFilters MovieFilters, fn func(*Movie) error) error {
+	clause, args := m.filterClause(movieFilters)
+
+	query := `SELECT id, created_at, title, year, runtime, genres, version, poster, backdrop,
+	COALESCE(ratings.average_rating, 0), ratings.rating_count
+	` + clause + `
+	ORDER BY movies.id ASC`
+
+	// exporting the whole catalogue takes much longer than our usual 3-second timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.Poster,
+			&movie.Backdrop,
+			&movie.AverageRating,
+			&movie.RatingCount)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// MovieFields lists the fields of a movie which can be selected with fields=, and
+// MovieIncludes the related data which can be embedded in a movie with include=.
+var (
+	MovieFields   = []string{"id", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count", "poster", "backdrop"}
+	MovieIncludes = []string{"credits", "created_by", "releases"}
+)
+
+// MovieFacets lists the facets which can be requested alongside a movie listing: counts
+// of the matching movies per genre, and per decade of release.
+var MovieFacets = []string{"genres", "year"}
+
+// A FacetCount holds the number of movies which have a particular facet value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets maps a facet name to the counts for each of its values.
+type Facets map[string][]FacetCount
+
+// facetExpressions maps each facet name to an SQL expression returning the facet
+// value(s) of a movie. A movie is counted once for each of its genres.
+var facetExpressions = map[string]string{
+	"genres": "unnest(movies.genres)",
+	"year":   "(movies.year / 10 * 10)::text || 's'",
+}
+
+// Facets() counts the movies matching the filters for each value of the requested
+// facets, using the same filter clause as GetAll(). Pagination doesn't apply, so the
+// counts cover every matching movie rather than the current page.
+func (m MovieModel) Facets(movieFilters MovieFilters, names []string) (Facets, error) {
+	clause, args := m.filterClause(movieFilters)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	facets := Facets{}
+
+	for _, name := range names {
+		expression, ok := facetExpressions[name]
+		if !ok {
+			panic("unknown movie facet: " + name)
+		}
+
+		query := fmt.Sprintf(`SELECT value, count(*)
+			FROM (SELECT %s AS value %s) AS facet
+			GROUP BY value
+			ORDER BY count(*) DESC, value ASC`, expression, clause)
+
+		rows, err := m.DB.QueryContext(ctx, query, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		counts := []FacetCount{}
+
+		for rows.Next() {
+			var count FacetCount
+
+			err := rows.Scan(&count.Value, &count.Count)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			counts = append(counts, count)
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		facets[name] = counts
+	}
+
+	return facets, nil
+}
+
+// A TitleSuggestion is a short summary of a movie returned by Autocomplete().
+type TitleSuggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitempty"`
+}
+
+// Autocomplete() returns up to limit movies whose titles match the partially typed
+// query. Titles which start with the query come first, followed by full-text prefix
+// matches and misspellings, ordered by how similar they are.
+func (m MovieModel) Autocomplete(q string, limit int) ([]*TitleSuggestion, error) {
+	query := fmt.Sprintf(`SELECT id, title, year
+		FROM movies
+		WHERE deleted_at IS NULL
+		AND (to_tsvector('%[1]s', title) @@ to_tsquery('%[1]s', $1)
+		OR starts_with(lower(title), lower($2))
+		OR $2 <%% title)
+		ORDER BY starts_with(lower(title), lower($2)) DESC, word_similarity($2, title) DESC, id ASC
+		LIMIT $3`, m.searchLanguage())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, prefixQuery(q), q, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*TitleSuggestion{}
+
+	for rows.Next() {
+		var suggestion TitleSuggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
+
 //type MockMovieModel struct{}
 //
 ////
@@ -0,0 +1,173 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"time"
+)
+
+// Define a Person struct to represent a member of a movie's cast or crew.
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Biography string    `json:"biography,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	v.Check(len(person.Biography) <= 10_000, "biography", "must not be more than 10000 bytes long")
+}
+
+// Define a PersonModel struct type which wraps a sql.DB connection pool.
+type PersonModel struct {
+	DB *sql.DB
+}
+
+func (m PersonModel) Insert(person *Person) error {
+	query := `INSERT INTO people (name, biography)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	args := []any{person.Name, person.Biography}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, biography, version
+		FROM people
+		WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&person.ID,
+		&person.CreatedAt,
+		&person.Name,
+		&person.Biography,
+		&person.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+func (m PersonModel) Update(person *Person) error {
+	query := `UPDATE people
+		SET name = $1, biography = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []any{person.Name, person.Biography, person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m PersonModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM people WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// GetAll() returns a page of people, optionally filtered by a full-text search on the
+// name, in the same way that MovieModel.GetAll() searches on the title.
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, name, biography, version
+		FROM people
+		WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		ORDER BY %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(
+			&totalRecords,
+			&person.ID,
+			&person.CreatedAt,
+			&person.Name,
+			&person.Biography,
+			&person.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return people, metadata, nil
+}
@@ -0,0 +1,190 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"regexp"
+	"time"
+)
+
+// Define constants for the kinds of release that a movie can have in a country.
+const (
+	ReleaseTheatrical = "theatrical"
+	ReleaseDigital    = "digital"
+	ReleasePhysical   = "physical"
+	ReleaseTV         = "tv"
+)
+
+var ErrDuplicateRelease = errors.New("duplicate release")
+
+// CountryRx matches an ISO 3166-1 alpha-2 country code, such as "US" or "GB".
+var CountryRx = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// Certifications lists the age certifications of the rating system used in each
+// country. A release in a country which isn't listed here can't have a certification.
+var Certifications = map[string][]string{
+	"AU": {"G", "PG", "M", "MA15+", "R18+", "X18+"},
+	"BR": {"L", "10", "12", "14", "16", "18"},
+	"CA": {"G", "PG", "14A", "18A", "R"},
+	"DE": {"0", "6", "12", "16", "18"},
+	"ES": {"APTA", "7", "12", "16", "18"},
+	"FR": {"TP", "12", "16", "18"},
+	"GB": {"U", "PG", "12A", "12", "15", "18", "R18"},
+	"IE": {"G", "PG", "12A", "15A", "16", "18"},
+	"IN": {"U", "UA", "UA 7+", "UA 13+", "UA 16+", "A", "S"},
+	"IT": {"T", "6+", "14+", "18+"},
+	"JP": {"G", "PG12", "R15+", "R18+"},
+	"KR": {"ALL", "12", "15", "18"},
+	"NL": {"AL", "6", "9", "12", "14", "16", "18"},
+	"NZ": {"G", "PG", "M", "R13", "R15", "R16", "R18", "RP13", "RP16"},
+	"US": {"G", "PG", "PG-13", "R", "NC-17", "NR"},
+}
+
+// A Release is the date that a movie was (or will be) released in a country in one
+// way, such as in cinemas or for streaming, along with the age certification it was
+// given there. The date is in the "2006-01-02" format.
+type Release struct {
+	ID            int64  `json:"id"`
+	MovieID       int64  `json:"-"`
+	Country       string `json:"country"`
+	Type          string `json:"type"`
+	Date          string `json:"date"`
+	Certification string `json:"certification,omitempty"`
+}
+
+func ValidateRelease(v *validator.Validator, release *Release) {
+	v.Check(release.Country != "", "country", "must be provided")
+	v.Check(validator.Matches(release.Country, CountryRx), "country", "must be a two-letter ISO 3166-1 country code")
+
+	v.Check(release.Type != "", "type", "must be provided")
+	v.Check(validator.In(release.Type, ReleaseTheatrical, ReleaseDigital, ReleasePhysical, ReleaseTV), "type", "must be one of theatrical, digital, physical or tv")
+
+	v.Check(release.Date != "", "date", "must be provided")
+	_, err := time.Parse("2006-01-02", release.Date)
+	v.Check(err == nil, "date", "must be a date in the YYYY-MM-DD format")
+
+	// the certification must belong to the rating system of the release's country.
+	if release.Certification != "" {
+		certifications, ok := Certifications[release.Country]
+		v.Check(ok, "certification", "must not be provided for a country without a known rating system")
+		v.Check(!ok || validator.In(release.Certification, certifications...), "certification", "must be a certification of the country's rating system")
+	}
+}
+
+// Define a ReleaseModel struct type which wraps a sql.DB connection pool.
+type ReleaseModel struct {
+	DB *sql.DB
+}
+
+// Insert() adds a release for a movie. A movie can only have one release of each type
+// per country; if there is one already we return ErrDuplicateRelease.
+func (m ReleaseModel) Insert(release *Release) error {
+	query := `INSERT INTO movie_releases (movie_id, country, type, release_date, certification)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+
+	args := []any{release.MovieID, release.Country, release.Type, release.Date, release.Certification}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&release.ID)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "movie_releases_movie_id_country_type_key"`:
+			return ErrDuplicateRelease
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAllForMovie() returns the releases of a movie, by country and then by date.
+func (m ReleaseModel) GetAllForMovie(movieID int64) ([]*Release, error) {
+	releases, err := m.GetAllForMovies([]int64{movieID})
+	if err != nil {
+		return nil, err
+	}
+
+	if releases[movieID] == nil {
+		return []*Release{}, nil
+	}
+	return releases[movieID], nil
+}
+
+// GetAllForMovies() returns the releases of several movies at once, keyed by movie ID,
+// in the same order as GetAllForMovie(). Movies without any releases are left out of
+// the map.
+func (m ReleaseModel) GetAllForMovies(movieIDs []int64) (map[int64][]*Release, error) {
+	query := `SELECT id, movie_id, country, type, to_char(release_date, 'YYYY-MM-DD'), certification
+		FROM movie_releases
+		WHERE movie_id = ANY($1)
+		ORDER BY movie_id, country, release_date, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	releases := make(map[int64][]*Release)
+
+	for rows.Next() {
+		var release Release
+
+		err := rows.Scan(
+			&release.ID,
+			&release.MovieID,
+			&release.Country,
+			&release.Type,
+			&release.Date,
+			&release.Certification,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		releases[release.MovieID] = append(releases[release.MovieID], &release)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return releases, nil
+}
+
+// Delete() removes a release from a movie. The movie ID is checked so that a release
+// can't be deleted through the URL of a different movie.
+func (m ReleaseModel) Delete(movieID, id int64) error {
+	if movieID < 1 || id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM movie_releases WHERE id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight.mayuraandrew.tech/internal/validator"
+	"time"
+)
+
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+// Define a Review struct to hold a single user's rating (and optional review text) for
+// a movie. The Version field is used for optimistic locking in the same way as for
+// movies.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	Rating    int32     `json:"rating"`
+	Body      string    `json:"body"`
+	Version   int32     `json:"version"`
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Rating != 0, "rating", "must be provided")
+	v.Check(review.Rating >= 1, "rating", "must be at least 1")
+	v.Check(review.Rating <= 10, "rating", "must not be more than 10")
+
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// Define a ReviewModel struct type which wraps a sql.DB connection pool.
+type ReviewModel struct {
+	DB *sql.DB
+}
+
+// Insert a new review. A user can only review each movie once, so if the
+// "reviews_movie_id_user_id_key" constraint is violated we return ErrDuplicateReview.
+// Movies in the trash can't be reviewed, and we return ErrRecordNotFound for them.
+func (m ReviewModel) Insert(review *Review) error {
+	query := `INSERT INTO reviews (movie_id, user_id, rating, body)
+		SELECT id, $2::bigint, $3::integer, $4::text FROM movies WHERE id = $1 AND deleted_at IS NULL
+		RETURNING id, created_at, version`
+
+	args := []any{review.MovieID, review.UserID, review.Rating, review.Body}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "reviews_movie_id_user_id_key"`:
+			return ErrDuplicateReview
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// Get() retrieves a specific review for a movie. Both IDs are checked so that a review
+// can't be reached through the URL of a different movie, and the reviews of movies in
+// the trash can't be reached at all.
+func (m ReviewModel) Get(movieID, id int64) (*Review, error) {
+	if movieID < 1 || id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT reviews.id, reviews.created_at, reviews.movie_id, reviews.user_id, reviews.rating, reviews.body, reviews.version
+		FROM reviews
+		INNER JOIN movies ON movies.id = reviews.movie_id AND movies.deleted_at IS NULL
+		WHERE reviews.id = $1 AND reviews.movie_id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, movieID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.Rating,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// GetAllForMovie() returns a page of reviews for a specific movie, along with the
+// pagination metadata, using the same Filters struct as the movie listing.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, created_at, movie_id, user_id, rating, body, version
+		FROM reviews
+		WHERE movie_id = $1 AND EXISTS (SELECT 1 FROM movies WHERE movies.id = reviews.movie_id AND movies.deleted_at IS NULL)
+		ORDER BY %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.Rating,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return reviews, metadata, nil
+}
+
+// Update() changes the rating and body of a review, checking against the version
+// number to prevent edit conflicts.
+func (m ReviewModel) Update(review *Review) error {
+	query := `UPDATE reviews
+		SET rating = $1, body = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []any{review.Rating, review.Body, review.ID, review.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&review.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m ReviewModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM reviews WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
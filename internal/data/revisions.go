@@ -0,0 +1,198 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// The actions recorded in the movie_revisions table.
+const (
+	RevisionInsert  = "insert"
+	RevisionUpdate  = "update"
+	RevisionDelete  = "delete"
+	RevisionRestore = "restore"
+)
+
+// A MovieRevision is a snapshot of a movie's fields as they were saved at a specific
+// version, along with what the change was and who made it. A revision is written for
+// every insert, update and delete by the MovieModel methods, so the history is kept
+// even after the movie itself has been deleted. UserID is nil if the user who made the
+// change has since been deleted.
+type MovieRevision struct {
+	ID        int64     `json:"id"`
+	MovieID   int64     `json:"movie_id"`
+	Version   int32     `json:"version"`
+	Action    string    `json:"action"`
+	UserID    *int64    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Title     string    `json:"title"`
+	Year      int32     `json:"year,omitempty"`
+	Runtime   Runtime   `json:"runtime,omitempty"`
+	Genres    []string  `json:"genres,omitempty"`
+}
+
+// A Creator is the user who created a movie, as embedded in a movie with
+// include=created_by. Only the public details of the user are shown.
+type Creator struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+// Define a MovieRevisionModel struct type which wraps a sql.DB connection pool.
+type MovieRevisionModel struct {
+	DB *sql.DB
+}
+
+// Get() retrieves the revision of a movie with a specific version number.
+func (m MovieRevisionModel) Get(movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, movie_id, version, action, user_id, created_at, title, year, runtime, genres
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	var revision MovieRevision
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.ID,
+		&revision.MovieID,
+		&revision.Version,
+		&revision.Action,
+		&revision.UserID,
+		&revision.CreatedAt,
+		&revision.Title,
+		&revision.Year,
+		&revision.Runtime,
+		pq.Array(&revision.Genres),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &revision, nil
+}
+
+// GetAllForMovie() returns a page of the revisions for a movie, along with the
+// pagination metadata. Because the history outlives the movie, a movie with no
+// revisions at all is reported as ErrRecordNotFound rather than as an empty page.
+func (m MovieRevisionModel) GetAllForMovie(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	if movieID < 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`SELECT count(*) OVER(), id, movie_id, version, action, user_id, created_at, title, year, runtime, genres
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.orderBy())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.ID,
+			&revision.MovieID,
+			&revision.Version,
+			&revision.Action,
+			&revision.UserID,
+			&revision.CreatedAt,
+			&revision.Title,
+			&revision.Year,
+			&revision.Runtime,
+			pq.Array(&revision.Genres),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// a page past the end still counts as found, as long as the movie has some history.
+	if totalRecords == 0 {
+		var exists bool
+
+		err = m.DB.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM movie_revisions WHERE movie_id = $1)`, movieID).Scan(&exists)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		if !exists {
+			return nil, Metadata{}, ErrRecordNotFound
+		}
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	return revisions, metadata, nil
+}
+
+// GetCreators() returns the users who created the movies, keyed by movie ID, from the
+// "insert" revision of each movie. Movies whose creator is unknown (because they were
+// created before revisions were recorded, or the user has been deleted) are left out.
+func (m MovieRevisionModel) GetCreators(movieIDs []int64) (map[int64]*Creator, error) {
+	query := `SELECT movie_revisions.movie_id, users.id, users.name
+		FROM movie_revisions
+		INNER JOIN users ON users.id = movie_revisions.user_id
+		WHERE movie_revisions.movie_id = ANY($1) AND movie_revisions.action = 'insert'`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(movieIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	creators := make(map[int64]*Creator)
+
+	for rows.Next() {
+		var (
+			movieID int64
+			creator Creator
+		)
+
+		err := rows.Scan(&movieID, &creator.ID, &creator.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		creators[movieID] = &creator
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return creators, nil
+}
@@ -37,27 +37,31 @@ func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
 	if err != nil {
 		return ErrInvalidRuntimeFormat
 	}
-	// Split the string to isolate the part containing the number
-	parts := strings.Split(unquotedJSONValue, " ")
 
-	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
-	}
+	// Convert the string to a Runtime type and assign this to the receiver. Note that we use
+	// use the * operator to deference the receiver (which is a pointer to a Runtime
+	// type) in order to set the underlying value of the pointer
+	*r, err = ParseRuntime(unquotedJSONValue)
+	return err
+}
+
+// ParseRuntime() parses a runtime in the "<runtime> mins" format used in JSON, for
+// places where it appears without the JSON quoting, such as CSV files or the query
+// string.
+func ParseRuntime(s string) (Runtime, error) {
+	// Split the string to isolate the part containing the number
+	parts := strings.Split(s, " ")
 
 	// Sanity check the parts of the string to make sure it was in the expected format.
 	// if it isn't, we return the ErrInvalidRuntimeFormat error again.
 
 	if len(parts) != 2 || parts[1] != "mins" {
-		return ErrInvalidRuntimeFormat
+		return 0, ErrInvalidRuntimeFormat
 	}
 	i, err := strconv.ParseInt(parts[0], 10, 32)
 	if err != nil {
-		return ErrInvalidRuntimeFormat
+		return 0, ErrInvalidRuntimeFormat
 	}
-	// Convert the int32 to a Runtime type and assign this to the receiver. Note that we use
-	// use the * operator to deference the receiver (which is a pointer to a Runtime
-	// type) in order to set the underlying value of the pointer
-	*r = Runtime(i)
 
-	return nil
+	return Runtime(i), nil
 }
@@ -16,6 +16,8 @@ import (
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeEmailChange    = "email-change"
 )
 
 // Define a Token struct to hold the data for an individual token.
@@ -118,3 +120,108 @@ func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllForUserExcept() deletes all tokens for a specific user and scope, apart from
+// the token with the given plaintext, which is kept.
+func (m TokenModel) DeleteAllForUserExcept(scope string, userID int64, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `DELETE FROM tokens WHERE scope = $1 AND user_id = $2 AND hash <> $3`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, userID, tokenHash[:])
+	return err
+}
+
+// Delete() deletes a single token with the given scope and plaintext.
+func (m TokenModel) Delete(scope, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `DELETE FROM tokens WHERE scope = $1 AND hash = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, scope, tokenHash[:])
+	return err
+}
+
+// A Session describes an authentication token of a user: when it was created and when
+// and where it was last used. Current is true for the token of the request that asked
+// for the sessions.
+type Session struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	IP         string     `json:"ip,omitempty"`
+	Current    bool       `json:"current"`
+}
+
+// Touch() records that an authentication token has been used from the given user agent
+// and IP address. To avoid writing to the database on every request, the row is only
+// updated when the last use was more than a minute ago, so a change of user agent or IP
+// address can take up to a minute to show.
+func (m TokenModel) Touch(tokenPlaintext, userAgent, ip string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `UPDATE tokens
+		SET last_used_at = NOW(), user_agent = $2, ip = $3
+		WHERE scope = $4 AND hash = $1
+		AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, tokenHash[:], userAgent, ip, ScopeAuthentication)
+	return err
+}
+
+// GetSessionsForUser() returns the unexpired authentication tokens of a user as
+// sessions, most recently used first. The token with the given plaintext is marked as
+// the current one.
+func (m TokenModel) GetSessionsForUser(userID int64, tokenPlaintext string) ([]*Session, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `SELECT created_at, last_used_at, expiry, user_agent, ip, hash = $3
+		FROM tokens
+		WHERE scope = $1 AND user_id = $2 AND expiry > NOW()
+		ORDER BY COALESCE(last_used_at, created_at) DESC, created_at DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, ScopeAuthentication, userID, tokenHash[:])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
@@ -18,15 +18,17 @@ var AnonymousUser = &User{}
 // Define a User struct to represent an individual user. Importantly, notice how we are
 // using the json:"-" struct tag to prevent the Password and Version fields appearing in
 // any output when we encode it to JSON. Also notice that the Password field uses the
-// custom password type defined below
+// custom password type defined below. PendingEmail holds a new email address which the
+// user has asked to change to, until they confirm it.
 type User struct {
-	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  password  `json:"-"`
-	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	ID           int64     `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	PendingEmail string    `json:"pending_email,omitempty"`
+	Password     password  `json:"-"`
+	Activated    bool      `json:"activated"`
+	Version      int       `json:"-"`
 }
 
 // check is a User instance is the AnonymouseUser.
@@ -152,7 +154,7 @@ func (m UserModel) Insert(user *User) error {
 // return one record (or none at all, in which case we return a ErrRecordNotFound error)
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
-	query := `SELECT id, created_at, name, email, password_hash, activated, version
+	query := `SELECT id, created_at, name, email, COALESCE(pending_email, ''), password_hash, activated, version
 	FROM users 
 	WHERE email = $1`
 
@@ -166,6 +168,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.PendingEmail,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
@@ -191,7 +194,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 
 func (m UserModel) Update(user *User) error {
 	query := `UPDATE users 
-			SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
+			SET name = $1, email = $2, password_hash = $3, activated = $4, pending_email = NULLIF($7, ''), version = version + 1
 			WHERE id = $5 AND version = $6
 			RETURNING version`
 
@@ -202,6 +205,7 @@ func (m UserModel) Update(user *User) error {
 		user.Activated,
 		user.ID,
 		user.Version,
+		user.PendingEmail,
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -227,7 +231,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 
 	// set up the SQL query
-	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	query := `SELECT users.id, users.created_at, users.name, users.email, COALESCE(users.pending_email, ''), users.password_hash, users.activated, users.version
 		FROM users INNER JOIN tokens 
 		ON users.id = tokens.user_id
 		WHERE tokens.hash = $1 AND 
@@ -252,6 +256,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.PendingEmail,
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
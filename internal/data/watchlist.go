@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Define a WatchlistEntry struct to represent a movie on a user's personal watchlist.
+// WatchedAt is a pointer so that we can distinguish between a movie which hasn't been
+// watched yet (nil) and one which has.
+type WatchlistEntry struct {
+	UserID    int64      `json:"-"`
+	MovieID   int64      `json:"movie_id"`
+	AddedAt   time.Time  `json:"added_at"`
+	WatchedAt *time.Time `json:"watched_at"`
+}
+
+// Define a WatchlistModel struct type which wraps a sql.DB connection pool.
+type WatchlistModel struct {
+	DB *sql.DB
+}
+
+func (m WatchlistModel) Get(userID, movieID int64) (*WatchlistEntry, error) {
+	if movieID < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT watchlist.user_id, watchlist.movie_id, watchlist.added_at, watchlist.watched_at
+		FROM watchlist
+		INNER JOIN movies ON movies.id = watchlist.movie_id AND movies.deleted_at IS NULL
+		WHERE watchlist.user_id = $1 AND watchlist.movie_id = $2`
+
+	var entry WatchlistEntry
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID, movieID).Scan(
+		&entry.UserID,
+		&entry.MovieID,
+		&entry.AddedAt,
+		&entry.WatchedAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// Set() adds a movie to the user's watchlist, or updates the watched at timestamp if
+// the movie is already on it. The added_at value of an existing entry is preserved. If
+// the movie is in the trash, it returns ErrRecordNotFound.
+func (m WatchlistModel) Set(entry *WatchlistEntry) error {
+	query := `INSERT INTO watchlist (user_id, movie_id, watched_at)
+		SELECT $1::bigint, id, $3::timestamptz FROM movies WHERE id = $2 AND deleted_at IS NULL
+		ON CONFLICT (user_id, movie_id) DO UPDATE SET watched_at = EXCLUDED.watched_at
+		RETURNING added_at`
+
+	args := []any{entry.UserID, entry.MovieID, entry.WatchedAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.AddedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func (m WatchlistModel) Delete(userID, movieID int64) error {
+	if movieID < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM watchlist WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+)
+
+// Thumbnail() scales the image down to the given width, keeping its aspect ratio. Each
+// pixel of the thumbnail is the average of the pixels of the source image it covers
+// (a box filter), which gives good quality results when shrinking. Transparent areas
+// are composited onto a white background, so the result can be encoded as a JPEG. If
+// the image is already no wider than width, it is copied at its original size.
+func Thumbnail(src image.Image, width int) *image.RGBA {
+	bounds := src.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	if width > srcWidth {
+		width = srcWidth
+	}
+
+	height := srcHeight * width / srcWidth
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		// the rows of the source image covered by this row of the thumbnail.
+		y0 := bounds.Min.Y + y*srcHeight/height
+		y1 := bounds.Min.Y + (y+1)*srcHeight/height
+		if y1 == y0 {
+			y1++
+		}
+
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*srcWidth/width
+			x1 := bounds.Min.X + (x+1)*srcWidth/width
+			if x1 == x0 {
+				x1++
+			}
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					// RGBA() returns alpha-premultiplied 16-bit values.
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					n++
+				}
+			}
+
+			// composite the average colour over white: with premultiplied values
+			// this is just adding the part of the white background that shows through.
+			background := 0xffff - a/n
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8((r/n + background) >> 8),
+				G: uint8((g/n + background) >> 8),
+				B: uint8((b/n + background) >> 8),
+				A: 0xff,
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores files in a directory on the local filesystem. The files are expected
+// to be served at BaseURL, for example by an http.FileServer for the same directory.
+type Local struct {
+	Dir     string
+	BaseURL string
+}
+
+// NewLocal() returns a Local storage for the directory, creating it if necessary.
+func NewLocal(dir, baseURL string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// Put() writes the data to a temporary file first and then renames it into place, so
+// that a half-written file is never served.
+func (s *Local) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Local) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *Local) URL(key string) string {
+	return s.BaseURL + "/" + key
+}
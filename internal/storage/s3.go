@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 stores files as objects in a bucket of an S3-compatible object store, such as
+// Amazon S3 itself or a local MinIO server standing in for it during development.
+// Requests use path-style URLs ("<endpoint>/<bucket>/<key>") and are signed with AWS
+// Signature Version 4, which every S3-compatible store supports.
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// BaseURL is the public URL of the bucket, for example a CDN in front of it. If
+	// it is empty, URLs point straight at the bucket on the endpoint.
+	BaseURL string
+
+	Client *http.Client
+}
+
+// NewS3() returns an S3 storage for the bucket with a default HTTP client.
+func NewS3(endpoint, region, bucket, accessKey, secretKey, baseURL string) *S3 {
+	return &S3{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		BaseURL:   strings.TrimSuffix(baseURL, "/"),
+		Client:    &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	return s.do(ctx, http.MethodPut, key, data, contentType)
+}
+
+// Delete() removes an object. S3 returns success when deleting a missing object, so
+// there's nothing special to do for that case.
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	return s.do(ctx, http.MethodDelete, key, nil, "")
+}
+
+func (s *S3) URL(key string) string {
+	if s.BaseURL != "" {
+		return s.BaseURL + "/" + escapeKey(key)
+	}
+	return s.Endpoint + "/" + s.Bucket + "/" + escapeKey(key)
+}
+
+// do() sends a signed request for the object and checks that it succeeded.
+func (s *S3) do(ctx context.Context, method, key string, body []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.Endpoint+"/"+s.Bucket+"/"+escapeKey(key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("s3: %s %s: %s: %s", method, key, res.Status, bytes.TrimSpace(message))
+	}
+
+	return nil
+}
+
+// sign() adds the AWS Signature Version 4 Authorization header to the request. See
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html.
+func (s *S3) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	// the host header isn't in req.Header, so it is added to the signed headers here.
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapeKey() escapes each segment of a key for use in a URL path, keeping the
+// slashes between them.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
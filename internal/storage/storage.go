@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrInvalidKey is returned when a key can't safely be used as an object name or a
+// file path, for example because it contains "..".
+var ErrInvalidKey = errors.New("invalid storage key")
+
+// Storage is the interface for the places that uploaded files, such as movie posters,
+// can be kept. Keys are slash-separated paths like "movies/1/poster/original.jpg".
+// Files are written once and never modified; to change a file, write a new key and
+// delete the old one.
+type Storage interface {
+	// Put() stores the data under the key, replacing anything already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+
+	// Delete() removes the file stored under the key. Deleting a key which doesn't
+	// exist is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// URL() returns the public URL that clients can fetch the file from.
+	URL(key string) string
+}
+
+// validKey() reports whether a key is a relative slash-separated path without any
+// empty, "." or ".." segments.
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) {
+		return false
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,21 @@
+-- There is deliberately no foreign key on movie_id, so that the history of a movie is
+-- kept after the movie has been deleted.
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL,
+    version integer NOT NULL,
+    action text NOT NULL,
+    user_id bigint REFERENCES users ON DELETE SET NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    title text NOT NULL,
+    year integer NOT NULL,
+    runtime integer NOT NULL,
+    genres text[] NOT NULL,
+    CONSTRAINT movie_revisions_action_check CHECK (action IN ('insert', 'update', 'delete', 'restore')),
+    CONSTRAINT movie_revisions_movie_id_version_key UNIQUE (movie_id, version)
+);
+
+-- Record the current state of the existing movies as their first known revision.
+INSERT INTO movie_revisions (movie_id, version, action, created_at, title, year, runtime, genres)
+SELECT id, version, CASE WHEN version = 1 THEN 'insert' ELSE 'update' END, created_at, title, year, runtime, genres
+FROM movies;
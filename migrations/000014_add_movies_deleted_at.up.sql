@@ -0,0 +1,5 @@
+-- A movie is soft deleted by setting deleted_at. Trashed movies are purged for good by
+-- the application once they are older than the configured retention period.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,4 @@
+-- The poster and backdrop artwork of a movie, stored as JSON objects holding the URLs
+-- and storage keys of the uploaded image and its thumbnails.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster jsonb;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS backdrop jsonb;
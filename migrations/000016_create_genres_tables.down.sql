@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'genres:write';
+DROP TABLE IF EXISTS genre_names;
+DROP TABLE IF EXISTS genres;
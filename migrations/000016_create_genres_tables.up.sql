@@ -0,0 +1,74 @@
+-- Every movie must be left with at least one genre, so refuse to run if any movie only
+-- has blank genres, listing them so that they can be fixed first.
+DO $$
+DECLARE
+    ids text;
+BEGIN
+    SELECT string_agg(id::text, ', ' ORDER BY id) INTO ids
+    FROM movies
+    WHERE cardinality(genres) > 0
+    AND NOT EXISTS (SELECT 1 FROM unnest(genres) AS genre WHERE btrim(genre) <> '');
+
+    IF ids IS NOT NULL THEN
+        RAISE EXCEPTION 'these movies only have blank genres, give them a genre and run the migration again: %', ids;
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+-- Every genre is listed here under its own name and under each of its aliases, so
+-- that the unique index stops a name (in any case) from standing for two genres.
+CREATE TABLE IF NOT EXISTS genre_names (
+    name text NOT NULL,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS genre_names_name_key ON genre_names (lower(name));
+CREATE INDEX IF NOT EXISTS genre_names_genre_id_idx ON genre_names (genre_id);
+
+INSERT INTO permissions (code)
+VALUES
+    ('genres:write');
+
+-- Create a genre for each of the genres used by the existing movies, ignoring case and
+-- surrounding spaces, and named with its most common spelling.
+WITH spellings AS (
+    SELECT btrim(genre) AS name, count(*) AS uses
+    FROM movies, unnest(genres) AS genre
+    WHERE btrim(genre) <> ''
+    GROUP BY btrim(genre)
+), genre AS (
+    INSERT INTO genres (name)
+    SELECT DISTINCT ON (lower(name)) name FROM spellings
+    ORDER BY lower(name), uses DESC, name
+    RETURNING id, name
+)
+INSERT INTO genre_names (name, genre_id)
+SELECT name, id FROM genre;
+
+-- Rewrite the genres of the existing movies with those names, dropping any duplicates
+-- this creates. As when a genre is renamed or merged, each changed movie gets a new
+-- version, recorded as an "update" revision without a user.
+WITH normalized AS (
+    SELECT movies.id, ARRAY(
+        SELECT genres.name
+        FROM unnest(movies.genres) WITH ORDINALITY AS genre (name, position)
+        JOIN genre_names ON lower(genre_names.name) = lower(btrim(genre.name))
+        JOIN genres ON genres.id = genre_names.genre_id
+        GROUP BY genres.name
+        ORDER BY min(genre.position)
+    ) AS genres
+    FROM movies
+), movie AS (
+    UPDATE movies SET genres = normalized.genres, version = movies.version + 1
+    FROM normalized
+    WHERE movies.id = normalized.id AND movies.genres <> normalized.genres
+    RETURNING movies.id, movies.version, movies.title, movies.year, movies.runtime, movies.genres
+)
+INSERT INTO movie_revisions (movie_id, version, action, title, year, runtime, genres)
+SELECT id, version, 'update', title, year, runtime, genres FROM movie;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS movie_releases (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    country text NOT NULL,
+    type text NOT NULL,
+    release_date date NOT NULL,
+    certification text NOT NULL DEFAULT '',
+    CONSTRAINT movie_releases_country_check CHECK (country ~ '^[A-Z]{2}$'),
+    CONSTRAINT movie_releases_type_check CHECK (type IN ('theatrical', 'digital', 'physical', 'tv')),
+    CONSTRAINT movie_releases_movie_id_country_type_key UNIQUE (movie_id, country, type)
+);
+
+CREATE INDEX IF NOT EXISTS movie_releases_country_release_date_idx ON movie_releases (country, release_date);